@@ -2,21 +2,46 @@ package audio
 
 import (
 	"github.com/gordonklaus/portaudio"
-	"errors"
 	"flag"
-	"time"
+	"math"
 
 	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/wave"
 
 	. "github.com/sqweek/sqribe/core/types"
 )
 
+/* audioOps is implemented by each way of getting samples out: a portaudio
+ * stream (blocking or callback), or a simulated device for headless use */
 type audioOps interface {
-	Open(params portaudio.StreamParameters) (*portaudio.Stream, error)
 	Append(samples []int16) int
 	Prepare()
-	Started()
+	Start() error
+	Stop()
 	Index() (idx FrameN, ok bool)
+	Close()
+}
+
+/* paOps are the portaudio implementations, which open the global stream */
+type paOps interface {
+	audioOps
+	Open(params portaudio.StreamParameters) (*portaudio.Stream, error)
+}
+
+/* paStream provides the stream lifecycle shared by the paOps */
+type paStream struct{}
+
+func (paStream) Start() error {
+	return stream.Start()
+}
+
+func (paStream) Stop() {
+	stream.Abort()
+}
+
+func (paStream) Close() {
+	stream.Close()
+	portaudio.Terminate()
 }
 
 var useCallback = flag.Bool("cb", false, "use callback")
@@ -27,55 +52,53 @@ var stream *portaudio.Stream
 var stopped bool = true
 var fr, prevfr FrameRange
 var baseIndex, prevBase FrameN
-
-func HostApi() *portaudio.HostApiInfo {
-	/* TODO allow user to override host api */
-	for _, api := range PlatformHostApis() {
-		hostApi, err := portaudio.HostApi(api)
-		if err == nil {
-			return hostApi
-		}
-		log.AU.Printf("%v: %v", api, err)
-	}
-	return nil
-}
+var speed, prevSpeed float64 = 1, 1 // source frames per frame appended
 
 var (
 	Channels int
 	SampleRate int // aka Frame rate
 )
 
-func Open() error {
-	err := portaudio.Initialize()
+/* Open prepares the output. sink selects it: "" for the sound card, "null"
+ * to discard samples, or the name of a WAV file to record them to. The
+ * latter two keep time like a real device but don't need a sound card.
+ * dev chooses which sound card, and how to drive it. */
+func Open(sink string, dev Device) error {
+	if sink == "" {
+		return openPortAudio(dev)
+	}
+	sim, err := simOps(sink)
 	if err != nil {
 		return err
 	}
+	ops = sim
+	Channels, SampleRate = simChannels, simRate
+	log.AU.Printf("simulated output to '%s' (%d channels @ %d Hz)", sink, Channels, SampleRate)
+	return nil
+}
 
-	host := HostApi()
-	if host == nil {
-		return errors.New("no host APIs available!")
+func openPortAudio(want Device) error {
+	err := portaudio.Initialize()
+	if err != nil {
+		return err
 	}
-	dev := host.DefaultOutputDevice
-	params := portaudio.LowLatencyParameters(nil, dev)
-	l := params.Output.Latency
-	/* pulseaudio (via ALSA) uses heaps of CPU at the default low latency (~8ms) */
-	for params.Output.Latency < 30 * time.Millisecond {
-		if params.Output.Latency + l > dev.DefaultHighOutputLatency {
-			params.Output.Latency = dev.DefaultHighOutputLatency
-			break
-		}
-		params.Output.Latency += l
+	params, host, dev, err := streamParams(want)
+	if err != nil {
+		portaudio.Terminate()
+		return err
 	}
+	var pa paOps
 	if *useCallback {
-		ops = cbOps()
+		pa = cbOps(want.Buffer)
 	} else {
-		ops = blockOps(params.Output.Channels)
+		pa = blockOps(params.Output.Channels, want.Buffer)
 	}
-	s, err := ops.Open(params)
+	s, err := pa.Open(params)
 	if err != nil {
+		portaudio.Terminate()
 		return err
 	}
-	stream = s
+	ops, stream = pa, s
 	Channels = params.Output.Channels
 	SampleRate = int(params.SampleRate)
 
@@ -89,35 +112,80 @@ func Open() error {
 }
 
 func Shutdown() {
-	portaudio.Terminate()
+	if ops != nil {
+		ops.Close()
+		ops = nil
+	}
 }
 
-func Append(wav []int16) int {
-	n := ops.Append(wav)
+/* samples passed to Append are at srcRate; if that differs from the device
+ * they're resampled here, on the way out */
+var srcRate int
+var rs *wave.Resampler
+var resampled []float32
+var s16 []int16
+
+/* Append queues float samples on [-1,1] for playback, converting them to the
+ * device's rate and 16-bit format. Samples outside that range are clipped. */
+func Append(wav []float32) int {
+	out := wav
+	if rs != nil {
+		resampled = rs.Process(wav, resampled[:0])
+		out = resampled
+	}
+	if cap(s16) < len(out) {
+		s16 = make([]int16, len(out))
+	}
+	n := ops.Append(toS16(s16[:len(out)], out))
 	fr.Max += FrameN(n / Channels)
-	return n
+	return len(wav)
+}
+
+func toS16(dst []int16, src []float32) []int16 {
+	for i, x := range src {
+		v := math.Floor(float64(x) * 32768 + 0.5)
+		if v > 32767 {
+			v = 32767
+		} else if v < -32768 {
+			v = -32768
+		}
+		dst[i] = int16(v)
+	}
+	return dst
 }
 
-func Play(f0 FrameN) error {
+/* Play starts (or, if already playing, seeks) the stream. Subsequent calls
+ * to Append should provide audio from frame f0 onwards, at the given rate. */
+func Play(f0 FrameN, rate int) error {
+	return PlayAt(f0, rate, 1)
+}
+
+/* PlayAt is like Play, for audio which has been slowed down (or sped up) to
+ * the given speed, so PlayingFrame advances by speed source frames for every
+ * frame played. */
+func PlayAt(f0 FrameN, rate int, sp float64) error {
 	if stopped {
+		srcRate, rs = rate, nil
+		if rate != SampleRate {
+			rs = wave.NewResampler(rate, SampleRate, Channels)
+		}
 		baseIndex = 0
 		ops.Prepare()
-		if err := stream.Start(); err != nil {
+		if err := ops.Start(); err != nil {
 			return err
 		}
-		ops.Started()
 		stopped = false
 	} else {
-		prevfr, prevBase = fr, baseIndex
+		prevfr, prevBase, prevSpeed = fr, baseIndex, speed
 		baseIndex += (prevfr.Max - prevfr.Min)
 	}
-	fr = FrameRange{f0, f0}
+	fr, speed = FrameRange{f0, f0}, sp
 	return nil
 }
 
 func Stop() {
 	stopped = true
-	stream.Abort()
+	ops.Stop()
 }
 
 func IsPlaying() bool {
@@ -131,7 +199,21 @@ func PlayingFrame() (FrameN, bool) {
 	index, ok := ops.Index()
 	if index < baseIndex {
 		/* haven't looped around yet */
-		return prevfr.Min + (index - prevBase), ok
+		return prevfr.Min + toSource(index - prevBase, prevSpeed), ok
+	}
+	return fr.Min + toSource(index - baseIndex, speed), ok
+}
+
+/* converts a count of device frames to frames at the rate given to Play,
+ * played at speed sp. The frame ranges track device frames appended, so only
+ * offsets need it. */
+func toSource(n FrameN, sp float64) FrameN {
+	if (srcRate == SampleRate || srcRate == 0) && sp == 1 {
+		return n
+	}
+	r := sp
+	if srcRate != 0 {
+		r *= float64(srcRate) / float64(SampleRate)
 	}
-	return fr.Min + (index - baseIndex), ok
+	return FrameN(float64(n) * r)
 }
@@ -8,14 +8,18 @@ import (
 )
 
 type blockingOps struct {
+	paStream
 	buf []int16
 	pos int
 	writes int
 	frameDelay FrameN
 }
 
-func blockOps(channels int) *blockingOps {
-	return &blockingOps{buf: make([]int16, 1024 * channels)}
+func blockOps(channels, frames int) *blockingOps {
+	if frames <= 0 {
+		frames = 1024
+	}
+	return &blockingOps{buf: make([]int16, frames * channels)}
 }
 
 func (block *blockingOps) Open(params portaudio.StreamParameters) (s *portaudio.Stream, err error) {
@@ -48,9 +52,6 @@ func (block *blockingOps) Prepare() {
 	block.writes = 0
 }
 
-func (block *blockingOps) Started() {
-}
-
 func (block *blockingOps) Index() (FrameN, bool) {
 	written := FrameN((block.writes * len(block.buf) + block.pos) / Channels)
 	if written < block.frameDelay {
@@ -8,18 +8,24 @@ import (
 )
 
 type callbackOps struct {
+	paStream
+	frames int // per callback
 	buf *RingBuffer
 	index FrameN
 	timing portaudio.StreamCallbackTimeInfo
 }
 
 
-func cbOps() *callbackOps {
-	return &callbackOps{buf: NewRingBuffer(2048 * 3)}
+func cbOps(frames int) *callbackOps {
+	if frames <= 0 {
+		frames = 2048
+	}
+	return &callbackOps{frames: frames}
 }
 
 func (cb *callbackOps) Open(params portaudio.StreamParameters) (*portaudio.Stream, error) {
-	params.FramesPerBuffer = 2048
+	params.FramesPerBuffer = cb.frames
+	cb.buf = NewRingBuffer(cb.frames * params.Output.Channels * 3)
 	return portaudio.OpenStream(params, paCallback)
 }
 
@@ -44,9 +50,6 @@ func (cb *callbackOps) Prepare() {
 	cb.index = 0
 }
 
-func (cb *callbackOps) Started() {
-}
-
 func (cb *callbackOps) Index() (FrameN, bool) {
 	if cb.timing.CurrentTime == 0 {
 		return cb.index, true
@@ -0,0 +1,155 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+
+	"github.com/sqweek/sqribe/log"
+)
+
+/* Device describes which sound card to use and how to drive it. Zero values
+ * leave the choice to sqribe/portaudio. */
+type Device struct {
+	HostApi string // eg. "ALSA", "JACK", "ASIO", "WASAPI"; matched against the API's name
+	Name string // output device; exact name, or a unique substring of it
+	SampleRate int
+	Buffer int // frames per buffer
+	Latency time.Duration // target output latency
+	Fallback bool // use the default host API/device if the named one isn't available
+}
+
+/* HostApi returns the first host API available from the platform's preferences */
+func HostApi() *portaudio.HostApiInfo {
+	for _, api := range PlatformHostApis() {
+		hostApi, err := portaudio.HostApi(api)
+		if err == nil {
+			return hostApi
+		}
+		log.AU.Printf("%v: %v", api, err)
+	}
+	return nil
+}
+
+func findHostApi(name string) (*portaudio.HostApiInfo, error) {
+	if name == "" {
+		if host := HostApi(); host != nil {
+			return host, nil
+		}
+		return nil, errors.New("no host APIs available!")
+	}
+	apis, err := portaudio.HostApis()
+	if err != nil {
+		return nil, err
+	}
+	for _, api := range apis {
+		if strings.EqualFold(api.Name, name) || strings.EqualFold(api.Type.String(), name) {
+			return api, nil
+		}
+	}
+	return nil, fmt.Errorf("host API '%s' not available", name)
+}
+
+func findDevice(host *portaudio.HostApiInfo, name string) (*portaudio.DeviceInfo, error) {
+	if name == "" {
+		if host.DefaultOutputDevice == nil {
+			return nil, fmt.Errorf("%s has no default output device", host.Name)
+		}
+		return host.DefaultOutputDevice, nil
+	}
+	var found *portaudio.DeviceInfo
+	for _, dev := range host.Devices {
+		if dev.MaxOutputChannels == 0 {
+			continue
+		}
+		if dev.Name == name {
+			return dev, nil
+		}
+		if strings.Contains(strings.ToLower(dev.Name), strings.ToLower(name)) {
+			if found != nil {
+				return nil, fmt.Errorf("device '%s' is ambiguous: '%s' or '%s'", name, found.Name, dev.Name)
+			}
+			found = dev
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no output device '%s' in %s", name, host.Name)
+	}
+	return found, nil
+}
+
+func streamParams(want Device) (params portaudio.StreamParameters, host *portaudio.HostApiInfo, dev *portaudio.DeviceInfo, err error) {
+	if host, err = findHostApi(want.HostApi); err != nil {
+		if !want.Fallback || want.HostApi == "" {
+			return
+		}
+		log.AU.Printf("%v; using the default", err)
+		if host, err = findHostApi(""); err != nil {
+			return
+		}
+		want.Name = "" // a device from the missing API won't be found here either
+	}
+	if dev, err = findDevice(host, want.Name); err != nil {
+		if !want.Fallback || want.Name == "" {
+			return
+		}
+		log.AU.Printf("%v; using the default", err)
+		if dev, err = findDevice(host, ""); err != nil {
+			return
+		}
+	}
+	params = portaudio.LowLatencyParameters(nil, dev)
+	if want.Latency > 0 {
+		params.Output.Latency = want.Latency
+	} else {
+		l := params.Output.Latency
+		/* pulseaudio (via ALSA) uses heaps of CPU at the default low latency (~8ms) */
+		for params.Output.Latency < 30 * time.Millisecond {
+			if params.Output.Latency + l > dev.DefaultHighOutputLatency {
+				params.Output.Latency = dev.DefaultHighOutputLatency
+				break
+			}
+			params.Output.Latency += l
+		}
+	}
+	if want.SampleRate > 0 {
+		params.SampleRate = float64(want.SampleRate)
+	}
+	return
+}
+
+/* ListDevices describes the available host APIs and their output devices.
+ * Defaults are marked with a '*'. */
+func ListDevices(w io.Writer) error {
+	if err := portaudio.Initialize(); err != nil {
+		return err
+	}
+	defer portaudio.Terminate()
+	apis, err := portaudio.HostApis()
+	if err != nil {
+		return err
+	}
+	def := HostApi()
+	for _, api := range apis {
+		mark := " "
+		if def != nil && api.Name == def.Name {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s %s\n", mark, api.Name)
+		for _, dev := range api.Devices {
+			if dev.MaxOutputChannels == 0 {
+				continue
+			}
+			mark = " "
+			if dev == api.DefaultOutputDevice {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "   %s %s (%d channels @ %gHz, latency %v-%v)\n", mark, dev.Name, dev.MaxOutputChannels, dev.DefaultSampleRate, dev.DefaultLowOutputLatency, dev.DefaultHighOutputLatency)
+		}
+	}
+	return nil
+}
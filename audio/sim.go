@@ -0,0 +1,117 @@
+package audio
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	simRate = 44100
+	simChannels = 2
+	simBuffer = FrameN(2048) // how far Append may get ahead of the clock
+)
+
+/* simulatedOps stands in for a sound card. Samples are consumed in real time
+ * according to the wall clock, and either discarded or recorded to a WAV
+ * file, so playback behaves as it would on a real device. */
+type simulatedOps struct {
+	mu sync.Mutex
+	f *os.File
+	sink *wave.WAVWriter
+	start time.Time
+	running bool
+	written FrameN // frames appended since Prepare
+}
+
+func simOps(sink string) (*simulatedOps, error) {
+	sim := &simulatedOps{}
+	if sink != "null" {
+		f, err := os.Create(sink)
+		if err != nil {
+			return nil, err
+		}
+		if sim.sink, err = wave.NewWAVWriter(f, simRate, simChannels); err != nil {
+			f.Close()
+			return nil, err
+		}
+		sim.f = f
+	}
+	return sim, nil
+}
+
+/* frames the device has consumed; caller holds mu */
+func (sim *simulatedOps) clock() FrameN {
+	if !sim.running {
+		return 0
+	}
+	played := FrameN(time.Since(sim.start).Seconds() * simRate)
+	if played > sim.written {
+		/* underrun; a real device would be playing silence */
+		played = sim.written
+	}
+	return played
+}
+
+func (sim *simulatedOps) Append(samples []int16) int {
+	for {
+		sim.mu.Lock()
+		ahead := sim.written - sim.clock()
+		running := sim.running
+		sim.mu.Unlock()
+		if !running || ahead < simBuffer {
+			break
+		}
+		time.Sleep(time.Duration(ahead - simBuffer / 2) * time.Second / simRate)
+	}
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if sim.sink != nil {
+		if err := sim.sink.WriteS16(samples); err != nil {
+			log.AU.Println("recording output:", err)
+		}
+	}
+	sim.written += FrameN(len(samples) / simChannels)
+	return len(samples)
+}
+
+func (sim *simulatedOps) Prepare() {
+	sim.mu.Lock()
+	sim.written = 0
+	sim.mu.Unlock()
+}
+
+func (sim *simulatedOps) Start() error {
+	sim.mu.Lock()
+	sim.start = time.Now()
+	sim.running = true
+	sim.mu.Unlock()
+	return nil
+}
+
+func (sim *simulatedOps) Stop() {
+	sim.mu.Lock()
+	sim.running = false
+	sim.mu.Unlock()
+}
+
+func (sim *simulatedOps) Index() (FrameN, bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.clock(), true
+}
+
+func (sim *simulatedOps) Close() {
+	if sim.sink == nil {
+		return
+	}
+	if err := sim.sink.Close(); err != nil {
+		log.AU.Println("recording output:", err)
+	}
+	sim.f.Close()
+}
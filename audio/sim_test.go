@@ -0,0 +1,63 @@
+package audio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+/* appends nframes of silence in small blocks, as playback does */
+func appendFrames(nframes int) {
+	block := make([]float32, 64 * Channels)
+	for i := 0; i < nframes; i += 64 {
+		Append(block)
+	}
+}
+
+func TestSimulatedPlayback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqribe-audio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.wav")
+	if err = Open(out, Device{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Play(1000, SampleRate); err != nil {
+		t.Fatal(err)
+	}
+	/* Append is paced by the clock, so this takes ~100ms */
+	start := time.Now()
+	appendFrames(SampleRate / 5)
+	if elapsed := time.Since(start); elapsed < 50 * time.Millisecond {
+		t.Errorf("appending 200ms took only %v; not paced by the clock", elapsed)
+	}
+	f, playing := PlayingFrame()
+	if !playing || f <= 1000 || f > 1000 + FrameN(SampleRate / 5) {
+		t.Errorf("playing frame %d (playing:%t) after appending from 1000", f, playing)
+	}
+
+	/* loop back around; the position follows once the old samples are played */
+	Play(0, SampleRate)
+	appendFrames(SampleRate / 5)
+	if f, _ = PlayingFrame(); f > FrameN(SampleRate / 5) {
+		t.Errorf("playing frame %d after looping to 0", f)
+	}
+	Stop()
+	Shutdown()
+
+	st, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nframes := 2 * ((SampleRate / 5 + 63) / 64 * 64)
+	if expect := int64(44 + nframes * simChannels * 2); st.Size() != expect {
+		t.Errorf("recorded %d bytes, expected %d", st.Size(), expect)
+	}
+}
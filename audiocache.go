@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sqweek/sqribe/audio"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/plumb"
+	"github.com/sqweek/sqribe/wave"
+)
+
+/* Decoded audio is cached under App.Cache/decoded, keyed by the content of
+ * the audio file and the channel count it was decoded to, so that reopening a
+ * song doesn't have to decode it all over again. Audio is kept at the file's
+ * native rate; it's only resampled on its way to the output device. */
+func decodedDir() string {
+	return filepath.Join(App.Cache, "decoded")
+}
+
+func decodedCacheFile(audiofile string, channels int) (string, error) {
+	f, err := os.Open(audiofile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%x-%d.pcm", h.Sum(nil), channels)
+	return filepath.Join(decodedDir(), name), nil
+}
+
+/* openWaveform reuses a previously decoded cache if there is one, otherwise it starts decoding */
+func openWaveform(audiofile string, progress *plumb.Port, reply chan<- error) (*wave.Waveform, error) {
+	if err := os.MkdirAll(decodedDir(), 0777); err != nil {
+		return nil, err
+	}
+	cachefile, err := decodedCacheFile(audiofile, audio.Channels)
+	if err != nil {
+		return nil, err
+	}
+	if wav, err := wave.OpenCached(cachefile, 0, audio.Channels); err == nil {
+		now := time.Now()
+		for _, f := range wave.CacheFiles(cachefile) {
+			os.Chtimes(f, now, now) // bump for eviction
+		}
+		go func() { reply <- nil }()
+		return wav, nil
+	} else if !os.IsNotExist(err) {
+		log.WAV.Printf("can't reuse cache for %s: %v", audiofile, err)
+	}
+	go pruneDecoded(int64(Cfg.FS.CacheMB) * 1024 * 1024, cachefile)
+	return wave.NewWaveform(context.Background(), audiofile, cachefile, 0, audio.Channels, progress, reply)
+}
+
+type cacheEntry struct {
+	files []string
+	size int64
+	used time.Time
+}
+
+/* pruneDecoded evicts the least recently used caches until the total size is
+ * under limit. The cache named by keep is left alone. */
+func pruneDecoded(limit int64, keep string) {
+	pcms, err := filepath.Glob(filepath.Join(decodedDir(), "*.pcm"))
+	if err != nil {
+		log.FS.Println("pruning cache:", err)
+		return
+	}
+	entries := make([]cacheEntry, 0, len(pcms))
+	var total int64
+	for _, pcm := range pcms {
+		if pcm == keep {
+			continue
+		}
+		entry := cacheEntry{files: wave.CacheFiles(pcm)}
+		for _, f := range entry.files {
+			if st, err := os.Stat(f); err == nil {
+				entry.size += st.Size()
+				if st.ModTime().After(entry.used) {
+					entry.used = st.ModTime()
+				}
+			}
+		}
+		total += entry.size
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	for _, entry := range entries {
+		if total <= limit {
+			break
+		}
+		log.FS.Printf("evicting cache %s (%d bytes)", entry.files[0], entry.size)
+		for _, f := range entry.files {
+			os.Remove(f)
+		}
+		total -= entry.size
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/sqweek/dialog"
+
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+var bounceFile = flag.String("bounce", "", "render the mix of the given audio file to this WAV file and exit")
+
+/* Bounce renders rng through the same mix as playback and writes the result
+ * to a WAV file. It uses its own synth, so it can run alongside playback.
+ * Every note plays except those muted, whatever is soloed or auditioned. */
+func Bounce(wav *wave.Waveform, rng TimeRange, filename string) (err error) {
+	f0, fN := rng.MinFrame(), rng.MaxFrame()
+	if f0 >= fN {
+		return errors.New("nothing to render")
+	}
+	synth, err := SynthInit(wav.Rate(), Synth.SoundFont())
+	if err != nil {
+		return err
+	}
+	defer synth.Delete()
+	synth.SetTuning(Synth.Tuning())
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	out, err := wave.NewWAVWriter(f, wav.Rate(), wav.Channels)
+	if err != nil {
+		return err
+	}
+
+	md := newMixdown(synth, wav.Rate(), wav.Channels)
+	md.Seek(f0, fN, f0)
+	defer md.Stop()
+	nc := wav.Channels
+	skip := md.Latency() // output lags input by this many frames
+	in := make([]float32, int(mixBlock) * nc)
+	var done FrameN // output frames consumed so far
+	for frame := f0; done < skip + fN - f0 + 1; frame += mixBlock {
+		for i := range in {
+			in[i] = 0
+		}
+		if frame <= fN {
+			end := frame + mixBlock - 1
+			if end > fN {
+				end = fN
+			}
+			copy(in, wav.FloatFrames(frame, end))
+		}
+		mixed := md.Mix(in, frame + mixBlock)
+		/* trim the limiter's delay from the start and anything past fN */
+		i0, iN := FrameN(0), mixBlock
+		if done < skip {
+			i0 = skip - done
+			if i0 > mixBlock {
+				i0 = mixBlock
+			}
+		}
+		if extra := done + mixBlock - (skip + fN - f0 + 1); extra > 0 {
+			iN -= extra
+		}
+		if i0 < iN {
+			if err = out.Write(mixed[int(i0) * nc:int(iN) * nc]); err != nil {
+				return err
+			}
+		}
+		done += mixBlock
+	}
+	log.AU.Printf("rendered frames %d-%d to %s", f0, fN, filename)
+	return out.Close()
+}
+
+var bounceDlg = dialog.File().Title("sqribe - Render mix to WAV").Filter("WAV Files", "wav")
+
+/* bounce asks where to render the selected time range (or the whole song) */
+func bounce() {
+	if G.wav == nil {
+		return
+	}
+	rng := G.ww.SelectedTimeRange()
+	if rng.MinFrame() >= rng.MaxFrame() {
+		rng = G.ww.WaveRange()
+	}
+	go func() {
+		f, err := bounceDlg.Save()
+		if err == nil {
+			G.wav.Wait() // the end of the song isn't known until it's decoded
+			err = Bounce(G.wav, rng, f)
+		}
+		if err != nil && err != dialog.Cancelled {
+			alert("render failed: %v", err)
+		}
+	}()
+}
+
+/* main_bounce renders the whole song without opening a window, for -bounce */
+func main_bounce(audioFile string) error {
+	if audioFile == "" {
+		return errors.New("-bounce needs an audio file")
+	}
+	ld, err := Load(audioFile)
+	if err != nil {
+		return err
+	}
+	/* restoring the saved state updates the view too, which nobody's drawing */
+	redraw := make(chan Widget, 10)
+	go func() {
+		for _ = range redraw {
+		}
+	}()
+	G.ww = NewWaveWidget(redraw)
+	G.ww.SetScore(G.score)
+	ld.Pivot()
+	G.wav.Wait()
+	defer G.wav.Close()
+	return Bounce(G.wav, G.ww.WaveRange(), *bounceFile)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sqweek/dialog"
+
+	"github.com/sqweek/sqribe/audio"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/score"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	calibBPM = 100
+	calibClicks = 16
+	calibWarmup = 4 // taps ignored while getting into the rhythm
+)
+
+/* taps during calibration go to the running calibration's channel instead
+ * of becoming beats. taps is claimed before the dialog is shown, so only one
+ * calibration runs at a time. */
+var calib struct {
+	sync.Mutex
+	taps chan FrameN
+}
+
+/* claimCalib reserves taps for a new calibration, or returns false if one
+ * is already running */
+func claimCalib(taps chan FrameN) bool {
+	calib.Lock()
+	defer calib.Unlock()
+	if calib.taps != nil {
+		return false
+	}
+	calib.taps = taps
+	return true
+}
+
+/* releaseCalib stops taps going to taps, unless another calibration has
+ * taken over since */
+func releaseCalib(taps chan FrameN) {
+	calib.Lock()
+	defer calib.Unlock()
+	if calib.taps == taps {
+		calib.taps = nil
+	}
+}
+
+/* calibTap records a tap if calibration is running */
+func calibTap(f FrameN) bool {
+	calib.Lock()
+	taps := calib.taps
+	calib.Unlock()
+	if taps == nil {
+		return false
+	}
+	select {
+	case taps <- f:
+	default:
+	}
+	return true
+}
+
+/* tapOffset returns how far beats tapped with enter land after the music, in
+ * frames of the current recording */
+func tapOffset() FrameN {
+	if G.wav == nil {
+		return 0
+	}
+	return G.wav.FrameAtTime(time.Duration(Cfg.Audio.TapOffsetMs) * time.Millisecond)
+}
+
+/* addTappedBeat adds a beat for a tap at frame f, compensating for latency */
+func addTappedBeat(f FrameN) {
+	if f -= tapOffset(); f < 0 {
+		f = 0
+	}
+	G.score.AddBeat(f)
+}
+
+func click(t FrameN, rate int) float32 {
+	x := float64(t) / float64(rate)
+	if x > 0.05 {
+		return 0
+	}
+	return float32(0.5 * math.Sin(2 * math.Pi * 1000 * x) * math.Exp(-x / 0.01))
+}
+
+/* measureTaps finds the median delay of taps behind the clicks at multiples
+ * of period, and the spread (interquartile range) of those delays */
+func measureTaps(taps []FrameN, period FrameN) (offset, spread FrameN, ok bool) {
+	Δs := make([]int, 0, len(taps))
+	for _, tap := range taps {
+		k := FrameN(math.Floor(float64(tap) / float64(period) + 0.5))
+		if k <= calibWarmup || k > calibClicks {
+			continue
+		}
+		Δ := tap - k * period
+		if Δ < -period / 4 || Δ > period / 4 {
+			continue // not aimed at this click
+		}
+		Δs = append(Δs, int(Δ))
+	}
+	if len(Δs) < 4 {
+		return 0, 0, false
+	}
+	sort.Ints(Δs)
+	n := len(Δs)
+	return FrameN(Δs[n/2]), FrameN(Δs[3*n/4] - Δs[n/4]), true
+}
+
+/* calibrate plays a run of clicks and measures the user's taps against them,
+ * to find how late tapped beats land (reaction time plus output latency) */
+func calibrate() {
+	if G.transport.State() != STOPPED {
+		return
+	}
+	taps := make(chan FrameN, 4 * calibClicks)
+	if !claimCalib(taps) {
+		return
+	}
+	go func() {
+		defer releaseCalib(taps)
+		if !dialog.Message("Press enter in time with each of the %d clicks which follow. The first few are for getting into the rhythm.\n\nReady?", calibClicks).Title("sqribe - latency calibration").YesNo() {
+			return
+		}
+		rate := audio.SampleRate
+		period := FrameN(rate * 60 / calibBPM)
+		total := period * (calibClicks + 1)
+		/* after the last click, play silence until the output catches up so
+		 * late taps on it still count */
+		var deadline time.Time
+		complete := false
+		done := G.transport.PlayFunc(rate, func(f FrameN, block []float32) bool {
+			if f >= total {
+				if deadline.IsZero() {
+					deadline = time.Now().Add(time.Second)
+				}
+				played, _ := G.transport.FuncFrame()
+				if played >= total || time.Now().After(deadline) {
+					complete = true
+					return false
+				}
+			}
+			for i := FrameN(0); i < mixBlock; i++ {
+				var x float32
+				if k := (f + i) / period; k >= 1 && k <= calibClicks {
+					x = click((f + i) % period, rate)
+				}
+				for c := 0; c < audio.Channels; c++ {
+					block[int(i) * audio.Channels + c] = x
+				}
+			}
+			return true
+		})
+		if done == nil {
+			alert("couldn't start audio for calibration")
+			return
+		}
+		<-done
+		if !complete {
+			return // stopped early
+		}
+		releaseCalib(taps)
+		tapped := make([]FrameN, 0, calibClicks)
+		for len(taps) > 0 {
+			tapped = append(tapped, <-taps)
+		}
+		offset, spread, ok := measureTaps(tapped, period)
+		if !ok {
+			dialog.Message("Not enough taps matched the clicks; please try again.").Title("sqribe - latency calibration").Info()
+			return
+		}
+		ms := int(math.Floor(float64(offset) * 1000 / float64(rate) + 0.5))
+		spreadMs := int(float64(spread) * 1000 / float64(rate))
+		log.AU.Printf("calibration: taps %dms late (spread %dms) from %d taps", ms, spreadMs, len(tapped))
+		if !dialog.Message("Your taps landed %dms after the clicks (varying by about %dms). Compensate for this when adding beats from now on?", ms, spreadMs).Title("sqribe - latency calibration").YesNo() {
+			return
+		}
+		Δms := ms - Cfg.Audio.TapOffsetMs
+		Cfg.Audio.TapOffsetMs = ms
+		if err := WriteAudioConfig(); err != nil {
+			log.FS.Println("saving tap offset:", err)
+		}
+		if G.wav != nil && G.score.HasBeats() && Δms != 0 {
+			if dialog.Message("Shift the existing beats by %dms to match?", -Δms).Title("sqribe - latency calibration").YesNo() {
+				G.score.ShiftBeats(nil, -G.wav.FrameAtTime(time.Duration(Δms) * time.Millisecond))
+			}
+		}
+	}()
+}
+
+/* shiftBeats nudges the selected beats, or all of them if none are selected */
+func shiftBeats(Δ time.Duration) {
+	if G.wav == nil {
+		return
+	}
+	Δf := G.wav.FrameAtTime(Δ)
+	if Δ < 0 {
+		Δf = -G.wav.FrameAtTime(-Δ)
+	}
+	if beats, ok := G.ww.SelectedTimeRange().(score.BeatRange); ok {
+		G.score.ShiftBeats(&beats, Δf)
+	} else {
+		G.score.ShiftBeats(nil, Δf)
+	}
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"github.com/sqweek/fs"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/sqweek/sqribe/audio"
 	"github.com/sqweek/sqribe/log"
 )
 
@@ -13,10 +17,32 @@ type ConfigJSON struct {
 	FS struct {
 		SaveDir string
 		SoundFont string
+		CacheMB int // size limit for decoded audio kept between sessions (under the app cache dir, keyed by file content)
 	}
 	UI struct {
 		Scale int
 	}
+	Audio struct {
+		Sink string // "" for the sound card, "null" to discard output, or a WAV file to record it to
+		HostApi string // eg. "ALSA", "JACK", "ASIO"; see sqribe -list-devices
+		Device string // output device name, or a unique part of it
+		SampleRate int
+		Buffer int // frames per buffer
+		LatencyMs int // target output latency
+		TapOffsetMs int // how late beats tapped with enter land; measured by calibration (F8)
+	}
+	Playback struct {
+		CountIn int // metronome beats to count in before a loop starts
+		PreRollMs int // recording to play before the loop start on each pass
+		Practice struct {
+			Passes int // passes of the loop before each speed-up or advance
+			StartPercent int // speed to start at when speeding up
+			StepPercent int // speed increase at each step
+		}
+	}
+	Analysis struct {
+		SoundFontTemplates bool // render polyphonic templates with the soundfont rather than synthetic harmonics
+	}
 }
 
 var Cfg struct {
@@ -25,9 +51,14 @@ var Cfg struct {
 	mtime time.Time // mtime of the config file when it was loaded
 }
 
+func configPath() string {
+	return fs.SingleConfigPath(Usr, App, "sqribe.json")
+}
+
 func confinit() {
 	Cfg.FS.SaveDir = App.Docs
-	mtime, p, err := ReadConfig(fs.SingleConfigPath(Usr, App, "sqribe.json"))
+	Cfg.FS.CacheMB = 2048
+	mtime, p, err := ReadConfig(configPath())
 	if err == nil {
 		applyConfig(mtime, &p)
 	} else if !os.IsNotExist(err) {
@@ -57,9 +88,128 @@ func applyConfig(mtime time.Time, params *ConfigJSON) {
 	if params.FS.SoundFont != "" {
 		Cfg.FS.SoundFont = params.FS.SoundFont
 	}
+	if params.FS.CacheMB > 0 {
+		Cfg.FS.CacheMB = params.FS.CacheMB
+	}
 	if params.UI.Scale > 0 {
 		Cfg.UI.Scale = params.UI.Scale
 		yspacing = 2 * Cfg.UI.Scale
 	}
+	Cfg.Audio = params.Audio
+	Cfg.Playback = params.Playback
+	Cfg.Analysis = params.Analysis
 	Cfg.mtime = mtime
 }
+
+/* Stores the current audio settings in the config file. Only the Audio
+ * object is rewritten; other settings, including keys sqribe doesn't know
+ * about, are kept (though top-level keys come out in sorted order). */
+func WriteAudioConfig() error {
+	path := configPath()
+	file := make(map[string]json.RawMessage)
+	if buf, err := ioutil.ReadFile(path); err == nil {
+		if err = json.Unmarshal(buf, &file); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	a, err := mergeJSON(file["Audio"], &Cfg.Audio)
+	if err != nil {
+		return err
+	}
+	file["Audio"] = a
+	buf, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path, buf, 0666); err != nil {
+		return err
+	}
+	if st, err := os.Stat(path); err == nil {
+		Cfg.mtime = st.ModTime()
+	}
+	return nil
+}
+
+/* mergeJSON overlays the fields of v onto the JSON object orig, keeping any
+ * keys of orig which v doesn't have */
+func mergeJSON(orig json.RawMessage, v interface{}) (json.RawMessage, error) {
+	obj := make(map[string]json.RawMessage)
+	if len(orig) > 0 {
+		if err := json.Unmarshal(orig, &obj); err != nil {
+			return nil, err
+		}
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(buf, &fields); err != nil {
+		return nil, err
+	}
+	for k, f := range fields {
+		obj[k] = f
+	}
+	return json.Marshal(obj)
+}
+
+var (
+	listDevices = flag.Bool("list-devices", false, "list audio host APIs and output devices, then exit")
+	hostApiFlag = flag.String("hostapi", "", "audio host API (remembered in sqribe.json)")
+	deviceFlag = flag.String("device", "", "audio output device, or a unique part of its name (remembered)")
+	rateFlag = flag.Int("rate", 0, "audio output sample rate (remembered)")
+	bufferFlag = flag.Int("buffer", 0, "audio frames per buffer (remembered)")
+	latencyFlag = flag.Duration("latency", 0, "target audio output latency (remembered)")
+)
+
+/* Applies audio device options given on the command line over the config.
+ * Returns true if there were any, so they can be remembered. */
+func audioFlags() bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "hostapi":
+			Cfg.Audio.HostApi = *hostApiFlag
+		case "device":
+			Cfg.Audio.Device = *deviceFlag
+		case "rate":
+			Cfg.Audio.SampleRate = *rateFlag
+		case "buffer":
+			Cfg.Audio.Buffer = *bufferFlag
+		case "latency":
+			Cfg.Audio.LatencyMs = int(*latencyFlag / time.Millisecond)
+		default:
+			return
+		}
+		given = true
+	})
+	return given
+}
+
+/* deviceFlags returns true if -hostapi or -device was given on the command
+ * line, in which case failing to find it is an error rather than a reason
+ * to fall back to the default. */
+func deviceFlags() bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "hostapi" || f.Name == "device" {
+			given = true
+		}
+	})
+	return given
+}
+
+func (c *ConfigJSON) AudioDevice() audio.Device {
+	return audio.Device{
+		HostApi: c.Audio.HostApi,
+		Name: c.Audio.Device,
+		SampleRate: c.Audio.SampleRate,
+		Buffer: c.Audio.Buffer,
+		Latency: time.Duration(c.Audio.LatencyMs) * time.Millisecond,
+	}
+}
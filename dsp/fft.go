@@ -0,0 +1,61 @@
+package dsp
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+/* FFT performs an in-place radix-2 decimation-in-time transform.
+ * len(x) must be a power of two. */
+func FFT(x []complex128) {
+	n := len(x)
+	if n <= 1 {
+		return
+	}
+	/* bit reversal permutation */
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j & bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		θ := -2 * math.Pi / float64(size)
+		wn := cmplx.Rect(1, θ)
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start + k], w * x[start + k + size/2]
+				x[start + k] = a + b
+				x[start + k + size/2] = a - b
+				w *= wn
+			}
+		}
+	}
+}
+
+/* Pow2 returns the smallest power of two >= n */
+func Pow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+/* Hann returns a hann window of length n */
+func Hann(n int) []float64 {
+	w := make([]float64, n)
+	if n == 1 {
+		w[0] = 1
+		return w
+	}
+	for i := range w {
+		w[i] = 0.5 - 0.5 * math.Cos(2 * math.Pi * float64(i) / float64(n - 1))
+	}
+	return w
+}
@@ -0,0 +1,49 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func sine(freq float64, rate, n int) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = math.Sin(2 * math.Pi * freq * float64(i) / float64(rate))
+	}
+	return x
+}
+
+func TestFFTImpulse(t *testing.T) {
+	x := make([]complex128, 16)
+	x[0] = 1
+	FFT(x)
+	for i, c := range x {
+		if math.Abs(real(c) - 1) > 1e-9 || math.Abs(imag(c)) > 1e-9 {
+			t.Fatalf("bin %d: expected 1 got %v", i, c)
+		}
+	}
+}
+
+func TestSpectrumPeak(t *testing.T) {
+	for _, freq := range []float64{110, 440, 1234.5} {
+		s := MkSpectrum(sine(freq, 44100, 8192), 44100)
+		peaks := s.Peaks(1, 20, 10000, 60)
+		if len(peaks) != 1 {
+			t.Fatalf("%vHz: expected one peak, got %v", freq, peaks)
+		}
+		if math.Abs(peaks[0].Freq - freq) > s.BinWidth() / 4 {
+			t.Errorf("%vHz: peak found at %vHz", freq, peaks[0].Freq)
+		}
+	}
+}
+
+func TestChroma(t *testing.T) {
+	rate := 44100
+	spec := MkSpectrum(sine(261.63, rate, 8192), rate) // middle C
+	chroma := spec.Chroma(50, 5000, 0)
+	for pc, x := range chroma {
+		if pc != 0 && x > chroma[0] {
+			t.Errorf("pitch class %d (%g) stronger than C (%g)", pc, x, chroma[0])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package dsp
+
+import (
+	"math"
+)
+
+/* Limiter is a look-ahead peak limiter for interleaved float samples. The
+ * signal is delayed by the look-ahead period so that gain reduction can be
+ * ramped in before a peak arrives rather than clipping it or applying an
+ * instantaneous (audible) gain change. Once the peak has passed the gain
+ * recovers gradually. */
+type Limiter struct {
+	Ceiling float64
+
+	channels int
+	n int // look-ahead in frames
+	delay []float32 // n frames of delayed input
+	pos int
+	mins []minGain // monotonic queue for the sliding minimum of required gain
+	hist []float64 // last n sliding minimums, averaged to smooth the attack
+	sum float64
+	frame int
+	gain float64
+	release float64
+}
+
+type minGain struct {
+	frame int
+	gain float64
+}
+
+/* NewLimiter creates a limiter with a 5ms look-ahead and 100ms release. */
+func NewLimiter(rate, channels int) *Limiter {
+	n := rate / 200
+	if n < 1 {
+		n = 1
+	}
+	lim := &Limiter{
+		Ceiling: 0.98,
+		channels: channels,
+		n: n,
+		delay: make([]float32, n * channels),
+		hist: make([]float64, n),
+		sum: float64(n),
+		gain: 1.0,
+		release: 1 - math.Exp(-1 / (0.1 * float64(rate))),
+	}
+	for i := range lim.hist {
+		lim.hist[i] = 1.0
+	}
+	return lim
+}
+
+/* Reset empties the delay line and restores unity gain, eg. after a seek
+ * when the delayed samples are no longer wanted. */
+func (lim *Limiter) Reset() {
+	for i := range lim.delay {
+		lim.delay[i] = 0
+	}
+	for i := range lim.hist {
+		lim.hist[i] = 1.0
+	}
+	lim.mins = lim.mins[:0]
+	lim.pos, lim.frame = 0, 0
+	lim.sum, lim.gain = float64(lim.n), 1.0
+}
+
+/* Latency returns the delay, in frames, introduced by the limiter. */
+func (lim *Limiter) Latency() int {
+	return lim.n
+}
+
+/* Gain returns the gain applied to the most recent frame. */
+func (lim *Limiter) Gain() float64 {
+	return lim.gain
+}
+
+/* Process limits buf in place. The output lags the input by Latency() frames. */
+func (lim *Limiter) Process(buf []float32) {
+	nc := lim.channels
+	for i := 0; i + nc <= len(buf); i += nc {
+		peak := 0.0
+		for j := 0; j < nc; j++ {
+			peak = math.Max(peak, math.Abs(float64(buf[i + j])))
+		}
+		req := 1.0
+		if peak > lim.Ceiling {
+			req = lim.Ceiling / peak
+		}
+
+		/* minimum required gain over the current frame and the n before it;
+		 * averaging that over n frames yields a ramp which reaches the
+		 * required gain by the time the peak emerges from the delay line. */
+		t := lim.frame
+		for len(lim.mins) > 0 && lim.mins[len(lim.mins) - 1].gain >= req {
+			lim.mins = lim.mins[:len(lim.mins) - 1]
+		}
+		lim.mins = append(lim.mins, minGain{t, req})
+		if lim.mins[0].frame < t - lim.n {
+			lim.mins = lim.mins[1:]
+		}
+		m := lim.mins[0].gain
+		h := t % lim.n
+		lim.sum += m - lim.hist[h]
+		lim.hist[h] = m
+		α := math.Min(lim.sum / float64(lim.n), 1.0)
+		if α < lim.gain {
+			lim.gain = α
+		} else {
+			lim.gain += (α - lim.gain) * lim.release
+		}
+
+		d := lim.delay[lim.pos * nc:(lim.pos + 1) * nc]
+		for j := 0; j < nc; j++ {
+			d[j], buf[i + j] = buf[i + j], float32(lim.gain * float64(d[j]))
+		}
+		lim.pos = (lim.pos + 1) % lim.n
+		lim.frame++
+	}
+}
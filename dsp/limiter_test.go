@@ -0,0 +1,63 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLimiter(t *testing.T) {
+	rate := 44100
+	lim := NewLimiter(rate, 2)
+	n := lim.Latency()
+
+	/* quiet signal passes through untouched, just delayed */
+	quiet := make([]float32, 2 * 1000)
+	for i := range quiet {
+		quiet[i] = float32(0.5 * math.Sin(float64(i / 2) * 0.05))
+	}
+	out := append([]float32(nil), quiet...)
+	lim.Process(out)
+	for i := 2*n; i < len(out); i++ {
+		if out[i] != quiet[i - 2*n] {
+			t.Fatalf("sample %d = %f, expected %f", i, out[i], quiet[i - 2*n])
+		}
+	}
+
+	/* loud burst never exceeds the ceiling */
+	loud := make([]float32, 2 * 4000)
+	for i := range loud {
+		loud[i] = float32(3.0 * math.Sin(float64(i / 2) * 0.05))
+	}
+	lim.Process(loud)
+	for i, x := range loud {
+		if math.Abs(float64(x)) > lim.Ceiling + 1e-6 {
+			t.Fatalf("sample %d = %f exceeds ceiling", i, x)
+		}
+	}
+
+	/* and the gain recovers afterwards */
+	tail := make([]float32, 2 * rate)
+	for i := range tail {
+		tail[i] = float32(0.5 * math.Sin(float64(i / 2) * 0.05))
+	}
+	lim.Process(tail)
+	if lim.Gain() < 0.99 {
+		t.Errorf("gain %f after release", lim.Gain())
+	}
+
+	/* after a reset nothing from before comes out of the delay line */
+	lim.Process(loud[:2 * n])
+	lim.Reset()
+	out = append([]float32(nil), quiet...)
+	lim.Process(out)
+	for i := 0; i < 2*n; i++ {
+		if out[i] != 0 {
+			t.Fatalf("sample %d = %f after reset, expected silence", i, out[i])
+		}
+	}
+	for i := 2*n; i < len(out); i++ {
+		if out[i] != quiet[i - 2*n] {
+			t.Fatalf("sample %d = %f after reset, expected %f", i, out[i], quiet[i - 2*n])
+		}
+	}
+}
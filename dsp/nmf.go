@@ -0,0 +1,41 @@
+package dsp
+
+/* Activations decomposes the (non-negative) spectrum v into a non-negative
+ * weighting of the fixed templates w, ie. it finds h >= 0 such that
+ * v ≈ Σ h[i]*w[i]. The columns of the factorisation are fixed so only the
+ * activations are learnt, using multiplicative updates which minimise the
+ * generalised Kullback-Leibler divergence (Lee & Seung, 2001). */
+func Activations(v []float64, w [][]float64, iters int) []float64 {
+	const ε = 1e-12
+	h := make([]float64, len(w))
+	norm := make([]float64, len(w)) // column sums of w
+	for i, t := range w {
+		for _, x := range t {
+			norm[i] += x
+		}
+		h[i] = 1
+	}
+	approx := make([]float64, len(v))
+	for it := 0; it < iters; it++ {
+		for k := range approx {
+			approx[k] = ε
+		}
+		for i, t := range w {
+			for k, x := range t {
+				approx[k] += h[i] * x
+			}
+		}
+		for i, t := range w {
+			if norm[i] == 0 {
+				h[i] = 0
+				continue
+			}
+			num := 0.0
+			for k, x := range t {
+				num += x * v[k] / approx[k]
+			}
+			h[i] *= num / norm[i]
+		}
+	}
+	return h
+}
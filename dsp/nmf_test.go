@@ -0,0 +1,22 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestActivations(t *testing.T) {
+	w := [][]float64{
+		{1, 0, 0.5, 0, 0.25, 0},
+		{0, 1, 0, 0.5, 0, 0.25},
+		{1, 0, 0, 0, 0, 0},
+	}
+	v := make([]float64, 6)
+	for k := range v {
+		v[k] = 2 * w[0][k] + 0.5 * w[1][k]
+	}
+	h := Activations(v, w, 500)
+	if math.Abs(h[0] - 2) > 0.05 || math.Abs(h[1] - 0.5) > 0.05 || h[2] > 0.05 {
+		t.Errorf("expected activations [2 0.5 0], got %v", h)
+	}
+}
@@ -0,0 +1,143 @@
+package dsp
+
+import (
+	"math"
+	"sort"
+)
+
+/* Mono downmixes interleaved int16 samples to floats on [-1,1] */
+func Mono(samples []int16, channels int) []float64 {
+	n := len(samples) / channels
+	out := make([]float64, n)
+	for i := 0; i < n; i++ {
+		sum := 0.0
+		for j := 0; j < channels; j++ {
+			sum += float64(samples[i*channels + j])
+		}
+		out[i] = sum / float64(channels) / 32768.0
+	}
+	return out
+}
+
+type Spectrum struct {
+	Mag []float64 // magnitude of each bin, from DC up to (but excluding) nyquist
+	Rate int // sample rate of the analysed signal
+}
+
+/* MkSpectrum windows the signal and returns its magnitude spectrum. The
+ * signal is zero-padded up to the next power of two. */
+func MkSpectrum(signal []float64, rate int) Spectrum {
+	n := Pow2(len(signal))
+	win := Hann(len(signal))
+	x := make([]complex128, n)
+	for i, s := range signal {
+		x[i] = complex(s * win[i], 0)
+	}
+	FFT(x)
+	mag := make([]float64, n/2)
+	for i := range mag {
+		re, im := real(x[i]), imag(x[i])
+		mag[i] = math.Sqrt(re*re + im*im)
+	}
+	return Spectrum{mag, rate}
+}
+
+func (s Spectrum) BinWidth() float64 {
+	return float64(s.Rate) / float64(2 * len(s.Mag))
+}
+
+func (s Spectrum) Freq(bin float64) float64 {
+	return bin * s.BinWidth()
+}
+
+func (s Spectrum) Bin(freq float64) float64 {
+	return freq / s.BinWidth()
+}
+
+func (s Spectrum) Max() float64 {
+	max := 0.0
+	for _, m := range s.Mag {
+		if m > max {
+			max = m
+		}
+	}
+	return max
+}
+
+/* At returns the magnitude at a fractional bin, via linear interpolation */
+func (s Spectrum) At(bin float64) float64 {
+	if bin < 0 || bin >= float64(len(s.Mag) - 1) {
+		return 0
+	}
+	i := int(bin)
+	α := bin - float64(i)
+	return (1 - α) * s.Mag[i] + α * s.Mag[i+1]
+}
+
+type Peak struct {
+	Freq float64
+	Mag float64
+}
+
+/* Peaks returns up to n local maxima between fmin and fmax whose magnitude
+ * is no more than floor dB below the spectrum's maximum, loudest first.
+ * Peak frequencies are refined by parabolic interpolation. */
+func (s Spectrum) Peaks(n int, fmin, fmax, floor float64) []Peak {
+	thresh := s.Max() * math.Pow(10, -floor / 20)
+	lo, hi := int(s.Bin(fmin)), int(s.Bin(fmax))
+	if lo < 1 {
+		lo = 1
+	}
+	if hi > len(s.Mag) - 2 {
+		hi = len(s.Mag) - 2
+	}
+	peaks := make([]Peak, 0, 16)
+	for i := lo; i <= hi; i++ {
+		m := s.Mag[i]
+		if m < thresh || m <= s.Mag[i-1] || m < s.Mag[i+1] {
+			continue
+		}
+		a, b, c := s.Mag[i-1], m, s.Mag[i+1]
+		δ, denom := 0.0, a - 2*b + c
+		if denom != 0 {
+			δ = 0.5 * (a - c) / denom
+		}
+		peaks = append(peaks, Peak{s.Freq(float64(i) + δ), b - 0.25 * (a - c) * δ})
+	}
+	sort.Slice(peaks, func(i, j int) bool { return peaks[i].Mag > peaks[j].Mag })
+	if len(peaks) > n {
+		peaks = peaks[:n]
+	}
+	return peaks
+}
+
+/* Decibels converts a magnitude to dB relative to ref */
+func Decibels(mag, ref float64) float64 {
+	if mag <= 0 || ref <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(mag / ref)
+}
+
+/* Chroma folds the spectrum's energy between fmin and fmax into twelve pitch
+ * classes (0 = C), with semitone boundaries shifted by tuning cents. */
+func (s Spectrum) Chroma(fmin, fmax, tuning float64) [12]float64 {
+	var chroma [12]float64
+	lo, hi := int(s.Bin(fmin)), int(s.Bin(fmax))
+	if lo < 1 {
+		lo = 1
+	}
+	if hi > len(s.Mag) - 1 {
+		hi = len(s.Mag) - 1
+	}
+	for i := lo; i <= hi; i++ {
+		cents := 1200 * math.Log2(s.Freq(float64(i)) / 440) - tuning
+		pc := int(math.Floor(cents / 100 + 0.5)) + 9 // A is pitch class 9
+		pc %= 12
+		if pc < 0 {
+			pc += 12
+		}
+		chroma[pc] += s.Mag[i] * s.Mag[i]
+	}
+	return chroma
+}
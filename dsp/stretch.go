@@ -0,0 +1,236 @@
+package dsp
+
+import (
+	"math"
+)
+
+/* Stretcher changes the speed of interleaved float samples without changing
+ * their pitch, by WSOLA (waveform similarity overlap-add). Windows of input
+ * are overlap-added at a fixed output hop while the input advances by
+ * speed times that hop; each window's position is nudged within a small
+ * tolerance to best line up with the natural continuation of the previous
+ * one, which keeps the waveform coherent across the seams.
+ *
+ * At speed 1 samples pass straight through, unchanged. */
+type Stretcher struct {
+	channels int
+	n, hop, tol int // window length, output hop (n/2) and search tolerance, in frames
+	speed float64
+	window []float32
+
+	in []float32 // buffered input
+	pos float64 // nominal input frame of the next window, relative to in
+	prev int // input frame where the previous window started, or -1
+	acc []float32 // overlap-add accumulator, n frames
+	ready []float32 // output waiting to be read
+	mono []float32 // scratch space for the similarity search
+}
+
+/* NewStretcher creates a stretcher with 40ms windows and a 10ms search
+ * tolerance, initially at speed 1. */
+func NewStretcher(rate, channels int) *Stretcher {
+	n := (rate / 25) &^ 1
+	if n < 4 {
+		n = 4
+	}
+	st := &Stretcher{
+		channels: channels,
+		n: n,
+		hop: n / 2,
+		tol: rate / 100,
+		speed: 1,
+		window: make([]float32, n),
+		acc: make([]float32, n * channels),
+		prev: -1,
+	}
+	for i := range st.window {
+		/* periodic hann; windows half a window apart sum to 1 */
+		st.window[i] = float32(0.5 - 0.5 * math.Cos(2 * math.Pi * float64(i) / float64(n)))
+	}
+	return st
+}
+
+func (st *Stretcher) Speed() float64 {
+	return st.speed
+}
+
+/* SetSpeed changes the playback speed, eg. 0.5 for half speed. It applies
+ * to input not yet stretched, ie. from roughly the last 50ms written. */
+func (st *Stretcher) SetSpeed(speed float64) {
+	if speed == st.speed || speed <= 0 {
+		return
+	}
+	if speed == 1 {
+		st.release()
+	}
+	st.speed = speed
+}
+
+/* release hands buffered input straight to the output, when switching to
+ * speed 1. One last window at the natural continuation of the previous
+ * one completes the overlap-add. */
+func (st *Stretcher) release() {
+	nc := st.channels
+	start := 0
+	if st.prev >= 0 {
+		c := st.prev + st.hop
+		if (c + st.n) * nc <= len(st.in) {
+			st.add(c)
+			start = c + st.hop
+		} else {
+			start = c
+		}
+	}
+	if start * nc < len(st.in) {
+		st.ready = append(st.ready, st.in[start * nc:]...)
+	}
+	st.in = st.in[:0]
+	st.pos, st.prev = 0, -1
+	for i := range st.acc {
+		st.acc[i] = 0
+	}
+}
+
+/* Reset discards everything buffered, eg. after a seek */
+func (st *Stretcher) Reset() {
+	st.in, st.ready = st.in[:0], st.ready[:0]
+	st.pos, st.prev = 0, -1
+	for i := range st.acc {
+		st.acc[i] = 0
+	}
+}
+
+/* Write queues input samples */
+func (st *Stretcher) Write(samples []float32) {
+	if st.speed == 1 {
+		st.ready = append(st.ready, samples...)
+	} else {
+		st.in = append(st.in, samples...)
+	}
+}
+
+/* Read fills out with stretched samples. If there isn't enough input yet to
+ * fill it, nothing is read and it returns false. */
+func (st *Stretcher) Read(out []float32) bool {
+	for len(st.ready) < len(out) {
+		if st.speed == 1 || !st.step() {
+			return false
+		}
+	}
+	copy(out, st.ready)
+	st.ready = append(st.ready[:0], st.ready[len(out):]...)
+	return true
+}
+
+/* step overlap-adds one window, producing hop frames of output */
+func (st *Stretcher) step() bool {
+	nc := st.channels
+	frames := len(st.in) / nc
+	p := int(math.Floor(st.pos + 0.5))
+	need := p + st.tol + st.n
+	if st.prev >= 0 && st.prev + st.hop + st.n > need {
+		need = st.prev + st.hop + st.n
+	}
+	if frames < need {
+		return false
+	}
+	c := p
+	if st.prev >= 0 {
+		c = st.search(p)
+		st.add(c)
+	} else {
+		/* nothing to overlap with; take the first half at full gain */
+		for i := 0; i < st.n; i++ {
+			w := st.window[i]
+			if i < st.hop {
+				w = 1
+			}
+			for ch := 0; ch < nc; ch++ {
+				st.acc[i*nc + ch] += w * st.in[(c + i)*nc + ch]
+			}
+		}
+		st.emit()
+	}
+	st.prev = c
+	st.pos += float64(st.hop) * st.speed
+
+	/* drop input which no future window can reach */
+	lo := int(st.pos) - st.tol
+	if st.prev < lo {
+		lo = st.prev
+	}
+	if lo > 0 {
+		st.in = append(st.in[:0], st.in[lo * nc:]...)
+		st.pos -= float64(lo)
+		st.prev -= lo
+	}
+	return true
+}
+
+/* add overlap-adds the window starting at input frame c and emits a hop */
+func (st *Stretcher) add(c int) {
+	nc := st.channels
+	for i := 0; i < st.n; i++ {
+		w := st.window[i]
+		for ch := 0; ch < nc; ch++ {
+			st.acc[i*nc + ch] += w * st.in[(c + i)*nc + ch]
+		}
+	}
+	st.emit()
+}
+
+func (st *Stretcher) emit() {
+	h := st.hop * st.channels
+	st.ready = append(st.ready, st.acc[:h]...)
+	copy(st.acc, st.acc[h:])
+	for i := len(st.acc) - h; i < len(st.acc); i++ {
+		st.acc[i] = 0
+	}
+}
+
+/* search finds the window start within tol of p whose first half best
+ * matches (by normalised cross-correlation) the input which naturally
+ * followed the previous window */
+func (st *Stretcher) search(p int) int {
+	nc := st.channels
+	lo, hi := p - st.tol, p + st.tol
+	if lo < 0 {
+		lo = 0
+	}
+	/* work on a mono downmix of everything the candidates cover */
+	span := hi - lo + st.hop
+	if cap(st.mono) < span {
+		st.mono = make([]float32, span)
+	}
+	mono := st.mono[:span]
+	for i := range mono {
+		var x float32
+		for ch := 0; ch < nc; ch++ {
+			x += st.in[(lo + i)*nc + ch]
+		}
+		mono[i] = x
+	}
+	natural := st.prev + st.hop
+	template := make([]float32, 0, st.hop / 2 + 1)
+	for i := 0; i < st.hop; i += 2 {
+		var x float32
+		for ch := 0; ch < nc; ch++ {
+			x += st.in[(natural + i)*nc + ch]
+		}
+		template = append(template, x)
+	}
+	best, bestScore := p, math.Inf(-1)
+	for c := lo; c <= hi; c++ {
+		var xy, yy float64
+		for j, t := range template {
+			y := float64(mono[c - lo + 2*j])
+			xy += float64(t) * y
+			yy += y * y
+		}
+		score := xy / math.Sqrt(yy + 1e-9)
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best
+}
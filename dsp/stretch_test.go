@@ -0,0 +1,78 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func stereoSine(rate, frames int, freq float64) []float32 {
+	x := make([]float32, 2 * frames)
+	for i := 0; i < frames; i++ {
+		v := float32(0.5 * math.Sin(2 * math.Pi * freq * float64(i) / float64(rate)))
+		x[2*i], x[2*i + 1] = v, v
+	}
+	return x
+}
+
+/* counts upward zero crossings in the left channel */
+func crossings(x []float32) int {
+	n := 0
+	for i := 2; i < len(x); i += 2 {
+		if x[i - 2] < 0 && x[i] >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStretch(t *testing.T) {
+	rate := 44100
+	in := stereoSine(rate, rate, 440)
+
+	/* speed 1 passes samples straight through */
+	st := NewStretcher(rate, 2)
+	st.Write(in)
+	out := make([]float32, 2 * 64)
+	for i := 0; i + len(out) <= len(in); i += len(out) {
+		if !st.Read(out) {
+			t.Fatalf("ran out of samples at %d", i)
+		}
+		for j := range out {
+			if out[j] != in[i + j] {
+				t.Fatalf("sample %d = %f, expected %f", i + j, out[j], in[i + j])
+			}
+		}
+	}
+
+	/* at half speed, a second of input gives about two seconds of output at
+	 * the same pitch */
+	st = NewStretcher(rate, 2)
+	st.SetSpeed(0.5)
+	var stretched []float32
+	for i := 0; i < len(in); i += 2 * 1024 {
+		end := i + 2 * 1024
+		if end > len(in) {
+			end = len(in)
+		}
+		st.Write(in[i:end])
+		for st.Read(out) {
+			stretched = append(stretched, out...)
+		}
+	}
+	frames := len(stretched) / 2
+	if frames < 2 * rate - rate / 10 || frames > 2 * rate {
+		t.Errorf("half speed gave %d frames from %d", frames, rate)
+	}
+	/* skip the start and allow for the tail still buffered */
+	body := stretched[2 * rate / 10:]
+	freq := float64(crossings(body)) * float64(rate) / float64(len(body) / 2)
+	if math.Abs(freq - 440) > 10 {
+		t.Errorf("half speed changed pitch: %.1fHz", freq)
+	}
+	/* and no seams: a sine stays within its amplitude */
+	for i, x := range stretched {
+		if math.Abs(float64(x)) > 0.55 {
+			t.Fatalf("sample %d = %f; bad overlap", i, x)
+		}
+	}
+}
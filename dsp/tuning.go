@@ -0,0 +1,33 @@
+package dsp
+
+import (
+	"math"
+)
+
+/* TuningOffset estimates how far a set of frequencies sits from the A440
+ * equal tempered grid. Each peak's deviation from its nearest semitone is
+ * treated as an angle on a 100 cent circle so that deviations near ±50 cents
+ * don't cancel out, and the magnitude-weighted circular mean is returned in
+ * cents on [-50,50). confidence is the mean resultant length on [0,1]; it
+ * approaches 1 when all the peaks agree and 0 when they are scattered. */
+func TuningOffset(peaks []Peak) (cents, confidence float64) {
+	var x, y, total float64
+	for _, p := range peaks {
+		if p.Freq <= 0 || p.Mag <= 0 {
+			continue
+		}
+		c := 1200 * math.Log2(p.Freq / 440)
+		θ := 2 * math.Pi * c / 100
+		x += p.Mag * math.Cos(θ)
+		y += p.Mag * math.Sin(θ)
+		total += p.Mag
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	cents = 100 * math.Atan2(y, x) / (2 * math.Pi)
+	if cents >= 50 {
+		cents -= 100
+	}
+	return cents, math.Hypot(x, y) / total
+}
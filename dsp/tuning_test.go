@@ -0,0 +1,26 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTuningOffset(t *testing.T) {
+	/* A=435Hz is about -19.8 cents; harmonics share the same offset */
+	want := 1200 * math.Log2(435.0 / 440)
+	peaks := []Peak{{435, 1}, {870, 0.5}, {1305, 0.3}, {435 * math.Pow(2, 7.0/12), 0.8}}
+	cents, conf := TuningOffset(peaks)
+	if math.Abs(cents - want) > 2 {
+		t.Errorf("offset %.2f cents, expected %.2f", cents, want)
+	}
+	if conf < 0.95 {
+		t.Errorf("confidence %.2f for consistent peaks", conf)
+	}
+
+	/* offsets either side of the ±50 cent wrap must not average to zero */
+	q := math.Pow(2, 49.0/1200)
+	cents, _ = TuningOffset([]Peak{{440 * q, 1}, {440 * q * math.Pow(2, 2.0/1200), 1}})
+	if math.Abs(math.Abs(cents) - 50) > 2 {
+		t.Errorf("offset %.2f cents, expected ±50", cents)
+	}
+}
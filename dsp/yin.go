@@ -0,0 +1,93 @@
+package dsp
+
+import (
+	"math"
+)
+
+/* YIN estimates the fundamental frequency of a signal, as described in
+ * "YIN, a fundamental frequency estimator for speech and music"
+ * (de Cheveigné & Kawahara, 2002). The signal must span at least two periods
+ * of fmin. Returns the frequency and the aperiodicity of the estimate on
+ * [0,1] (lower is more confident), or a frequency of zero if no period
+ * below threshold was found. */
+func YIN(x []float64, rate int, fmin, fmax, threshold float64) (freq, aperiodicity float64) {
+	τmin := int(float64(rate) / fmax)
+	τmax := int(float64(rate) / fmin)
+	w := len(x) / 2
+	if τmax > w {
+		τmax = w
+	}
+	if τmin < 2 || τmin >= τmax {
+		return 0, 1
+	}
+	/* cumulative mean normalised difference function */
+	d := make([]float64, τmax + 1)
+	d[0] = 1
+	sum := 0.0
+	for τ := 1; τ <= τmax; τ++ {
+		diff := 0.0
+		for j := 0; j < w; j++ {
+			δ := x[j] - x[j + τ]
+			diff += δ * δ
+		}
+		sum += diff
+		if sum == 0 {
+			d[τ] = 1
+		} else {
+			d[τ] = diff * float64(τ) / sum
+		}
+	}
+	best := -1
+	for τ := τmin; τ <= τmax; τ++ {
+		if d[τ] < threshold {
+			/* walk down to the bottom of this dip */
+			for τ + 1 <= τmax && d[τ + 1] < d[τ] {
+				τ++
+			}
+			best = τ
+			break
+		}
+	}
+	if best == -1 {
+		return 0, 1
+	}
+	/* parabolic interpolation for sub-sample period accuracy */
+	period := float64(best)
+	if best > 1 && best < τmax {
+		a, b, c := d[best - 1], d[best], d[best + 1]
+		if denom := a - 2*b + c; denom != 0 {
+			period += 0.5 * (a - c) / denom
+		}
+	}
+	return float64(rate) / period, math.Max(d[best], 0)
+}
+
+type PitchFrame struct {
+	Offset int // index of the first sample in the analysis window
+	Freq float64 // zero if unvoiced
+	Aperiodicity float64
+	RMS float64
+}
+
+/* PitchTrack runs YIN over successive windows of the signal, spaced hop samples apart */
+func PitchTrack(x []float64, rate, window, hop int, fmin, fmax, threshold float64) []PitchFrame {
+	track := make([]PitchFrame, 0, len(x) / hop + 1)
+	for i := 0; i + window <= len(x); i += hop {
+		win := x[i:i + window]
+		pf := PitchFrame{Offset: i, RMS: RMS(win)}
+		pf.Freq, pf.Aperiodicity = YIN(win, rate, fmin, fmax, threshold)
+		track = append(track, pf)
+	}
+	return track
+}
+
+func RMS(x []float64) float64 {
+	if len(x) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, s := range x {
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(x)))
+}
@@ -0,0 +1,33 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestYIN(t *testing.T) {
+	rate := 44100
+	for _, freq := range []float64{82.4, 220, 440, 987.8} {
+		x := sine(freq, rate, 2048)
+		/* add a second harmonic so the waveform isn't a pure sine */
+		for i := range x {
+			x[i] += 0.5 * math.Sin(4 * math.Pi * freq * float64(i) / float64(rate))
+		}
+		f, ap := YIN(x, rate, 60, 1500, 0.15)
+		if math.Abs(f - freq) / freq > 0.005 {
+			t.Errorf("%vHz: estimated %vHz (aperiodicity %v)", freq, f, ap)
+		}
+	}
+}
+
+func TestYINNoise(t *testing.T) {
+	x := make([]float64, 2048)
+	seed := uint32(1)
+	for i := range x {
+		seed = seed * 1664525 + 1013904223
+		x[i] = float64(seed) / (1 << 31) - 1
+	}
+	if f, ap := YIN(x, 44100, 60, 1500, 0.1); f != 0 {
+		t.Errorf("white noise: expected unvoiced, got %vHz (aperiodicity %v)", f, ap)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/skelterjohn/go.wde"
+
+	"github.com/sqweek/sqribe/dsp"
+	"github.com/sqweek/sqribe/midi"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	inspectWindow = 8192 // frames analysed around the mouse
+	inspectMinFreq = 27.5 // A0
+	inspectMaxFreq = 4186 // C8
+	inspectRange = 60.0 // dB below the loudest bin that are displayed
+)
+
+/* SpectrumInspector draws the spectrum around the frame under the mouse in an overlay */
+type SpectrumInspector struct {
+	req chan image.Point
+}
+
+func (si *SpectrumInspector) Active() bool {
+	return si.req != nil
+}
+
+func (si *SpectrumInspector) Toggle() {
+	if si.req != nil {
+		close(si.req)
+		si.req = nil
+		return
+	}
+	si.req = make(chan image.Point, 1)
+	go si.loop(si.req)
+}
+
+/* Inspect requests a spectrum for the audio under the mouse. Stale requests are dropped. */
+func (si *SpectrumInspector) Inspect(mouse image.Point) {
+	if si.req == nil {
+		return
+	}
+	select {
+	case <-si.req:
+	default:
+	}
+	si.req <- mouse
+}
+
+func (si *SpectrumInspector) loop(req chan image.Point) {
+	overlay := G.overlay.Make()
+	for mouse := range req {
+		wav := G.wav
+		if wav == nil || !mouse.In(G.ww.rect.wave) {
+			continue
+		}
+		frame := G.ww.FrameAtPixel(mouse.X)
+		f0 := wav.ClipFrame(frame - inspectWindow/2)
+		fN := wav.ClipFrame(f0 + inspectWindow - 1)
+		if fN <= f0 {
+			continue
+		}
+		spec := dsp.MkSpectrum(dsp.Mono(wav.Frames(f0, fN), wav.Channels), wav.Rate())
+		view := SpectrumView{spec: spec, frame: frame}
+		view.peaks = spec.Peaks(6, inspectMinFreq, inspectMaxFreq, 30)
+		view.r = inspectRect(mouse, G.ww.rect.wave)
+		overlay.Update(view)
+	}
+	overlay.Close(true)
+}
+
+/* positions the inspector above/right of the mouse, but within bounds */
+func inspectRect(mouse image.Point, bounds image.Rectangle) image.Rectangle {
+	r := box(320, 160).Add(mouse).Add(image.Pt(16, -176))
+	if r.Max.X > bounds.Max.X {
+		r = r.Sub(image.Pt(r.Dx() + 32, 0))
+	}
+	if r.Min.Y < bounds.Min.Y {
+		r = r.Add(image.Pt(0, bounds.Min.Y - r.Min.Y))
+	}
+	return r
+}
+
+type SpectrumView struct {
+	r image.Rectangle
+	spec dsp.Spectrum
+	peaks []dsp.Peak
+	frame FrameN
+}
+
+func (v SpectrumView) Rect() image.Rectangle {
+	return v.r
+}
+
+// x coordinate of a frequency on the (logarithmic) frequency axis
+func (v SpectrumView) freqX(freq float64, r image.Rectangle) int {
+	α := math.Log(freq / inspectMinFreq) / math.Log(inspectMaxFreq / inspectMinFreq)
+	return r.Min.X + int(α * float64(r.Dx()))
+}
+
+func (v SpectrumView) Draw(dst wde.Image, r image.Rectangle) {
+	border := color.RGBA{0x99, 0x88, 0x88, 0xff}
+	bg := color.NRGBA{0x33, 0x22, 0x22, 0xee}
+	fg := color.NRGBA{0xcc, 0xcc, 0xbb, 0xff}
+	peakc := color.NRGBA{0xff, 0xcc, 0x00, 0xff}
+	drawBorders(dst, r, border, bg)
+	plot := r.Inset(2)
+	plot.Min.Y += G.font.luxi.PixelHeight()
+	ref := v.spec.Max()
+	if ref == 0 {
+		return
+	}
+	ratio := math.Log(inspectMaxFreq / inspectMinFreq)
+	for x := plot.Min.X; x < plot.Max.X; x++ {
+		f0 := inspectMinFreq * math.Exp(ratio * float64(x - plot.Min.X) / float64(plot.Dx()))
+		f1 := inspectMinFreq * math.Exp(ratio * float64(x + 1 - plot.Min.X) / float64(plot.Dx()))
+		/* take the loudest bin covered by this column so narrow peaks aren't lost */
+		mag := v.spec.At(v.spec.Bin(f0))
+		for bin := math.Ceil(v.spec.Bin(f0)); bin < v.spec.Bin(f1); bin++ {
+			mag = math.Max(mag, v.spec.At(bin))
+		}
+		db := dsp.Decibels(mag, ref)
+		if db < -inspectRange {
+			continue
+		}
+		h := int(float64(plot.Dy()) * (1 + db / inspectRange))
+		draw.Draw(dst, image.Rect(x, plot.Max.Y - h, x + 1, plot.Max.Y), &image.Uniform{fg}, image.ZP, draw.Over)
+	}
+	for _, pk := range v.peaks {
+		x := v.freqX(pk.Freq, plot)
+		h := int(float64(plot.Dy()) * (1 + dsp.Decibels(pk.Mag, ref) / inspectRange))
+		draw.Draw(dst, image.Rect(x, plot.Max.Y - h, x + 1, plot.Max.Y), &image.Uniform{peakc}, image.ZP, draw.Over)
+		G.font.luxi.DrawC(dst, peakc, r, noteLabel(pk.Freq), image.Pt(x, plot.Max.Y - h - 6))
+	}
+	label := fmt.Sprintf("%v  %s", G.wav.TimeAtFrame(v.frame), tuningStr())
+	G.font.luxi.Draw(dst, fg, image.Rect(r.Min.X + 2, r.Min.Y + 1, r.Max.X, r.Min.Y + 1 + G.font.luxi.PixelHeight()), label)
+}
+
+/* noteLabel names the pitch nearest to freq, accounting for the synth's tuning,
+ * along with the deviation in cents */
+func noteLabel(freq float64) string {
+	cents := FreqToCents(freq) - Synth.Tuning()
+	pitch := round(cents / 100)
+	if pitch < 0 || pitch > 127 {
+		return fmt.Sprintf("%.0fHz", freq)
+	}
+	return fmt.Sprintf("%s%+.0f", midi.PitchName(uint8(pitch)), cents - pitch * 100)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sqweek/dialog"
+	"github.com/sqweek/sqribe/dsp"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/score"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	keyWindow = 8192
+	keyWindows = 200
+	keyMinFreq = 55.0
+	keyMaxFreq = 2000.0
+	keyNoteWeight = 0.5 // share of the histogram contributed by placed notes, when there are any
+)
+
+/* audioChroma sums the chromagram of windows spread across rng */
+func audioChroma(wav *wave.Waveform, rng TimeRange) (chroma [12]float64) {
+	f0, fN := rng.MinFrame(), rng.MaxFrame()
+	if fN - f0 < keyWindow {
+		fN = wav.ClipFrame(f0 + keyWindow)
+	}
+	n := int((fN - f0) / keyWindow)
+	if n > keyWindows {
+		n = keyWindows
+	} else if n < 1 {
+		n = 1
+	}
+	step := (fN - f0 - keyWindow) / FrameN(n)
+	for i := 0; i < n; i++ {
+		f := f0 + FrameN(i) * step
+		x := dsp.Mono(wav.Frames(f, wav.ClipFrame(f + keyWindow - 1)), wav.Channels)
+		c := dsp.MkSpectrum(x, wav.Rate()).Chroma(keyMinFreq, keyMaxFreq, Synth.Tuning())
+		for pc := range chroma {
+			chroma[pc] += c[pc]
+		}
+	}
+	return chroma
+}
+
+/* noteHistogram totals the duration of placed notes by pitch class */
+func noteHistogram(sc *score.Score, rng TimeRange) (hist [12]float64, n int) {
+	for _, staff := range sc.Staves() {
+		for _, note := range staff.Notes() {
+			f := note.Beat.FrameAtRat(note.Offset)
+			if f < rng.MinFrame() || f > rng.MaxFrame() {
+				continue
+			}
+			hist[note.Pitch % 12] += flt(note.Duration)
+			n++
+		}
+	}
+	return hist, n
+}
+
+func normalise(hist *[12]float64) {
+	sum := 0.0
+	for _, x := range hist {
+		sum += x
+	}
+	if sum > 0 {
+		for i := range hist {
+			hist[i] /= sum
+		}
+	}
+}
+
+/* EstimateKey combines the recording's chromagram with the notes already
+ * transcribed over rng to guess the song's key. */
+func EstimateKey(wav *wave.Waveform, sc *score.Score, rng TimeRange) (score.Key, float64) {
+	hist := audioChroma(wav, rng)
+	normalise(&hist)
+	if notes, n := noteHistogram(sc, rng); n > 0 {
+		normalise(&notes)
+		for pc := range hist {
+			hist[pc] = (1 - keyNoteWeight) * hist[pc] + keyNoteWeight * notes[pc]
+		}
+	}
+	return score.EstimateKey(hist)
+}
+
+/* estimateKey analyses the selected range (or the whole song) in the background then offers to apply the result */
+func estimateKey() {
+	wav, sc := G.wav, G.score
+	if wav == nil {
+		return
+	}
+	rng := G.ww.SelectedTimeRange()
+	where := "The selection"
+	if rng == nil || rng.MaxFrame() <= rng.MinFrame() {
+		rng, where = wave.Range(wav), "The song"
+	}
+	go func() {
+		key, r := EstimateKey(wav, sc, rng)
+		log.UI.Printf("key estimate: %v (r=%.2f)", key, r)
+		if key.Sig == sc.Key() {
+			dialog.Message("%s already appears to be in %v", where, key).Title("sqribe - key").Info()
+			return
+		}
+		msg := fmt.Sprintf("%s appears to be in %v (correlation %.2f).\nChange the key signature to %v?", where, key, r, key.Sig)
+		if dialog.Message("%s", msg).Title("sqribe - key").YesNo() {
+			sc.SetKey(key.Sig)
+		}
+	}()
+}
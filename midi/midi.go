@@ -22,7 +22,10 @@ const (
 	InstViolin = 40
 	InstHarp = 46
 	InstVoice = 53
+	InstTinkleBell = 112
+	InstAgogo = 113
 	InstWoodblock = 115
+	InstMelodicTom = 117
 )
 
 var degreeNames []string = []string{"C", "Db", "D", "Eb", "E", "F", "Gb", "G", "Ab", "A", "Bb", "B"}
@@ -93,7 +96,10 @@ func init() {
 	inst(InstViolin, "Violin")
 	inst(InstHarp, "Harp")
 	inst(InstVoice, "Voice")
+	inst(InstTinkleBell, "Tinkle Bell")
+	inst(InstAgogo, "Agogo")
 	inst(InstWoodblock, "Woodblock")
+	inst(InstMelodicTom, "Melodic Tom")
 }
 
 func InstName(id int) string {
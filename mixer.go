@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"image/color"
 	"image/draw"
 	"image"
@@ -19,6 +20,7 @@ type MixVolume struct {
 type MixConfig struct {
 	Master, Midi, Wave MixVolume
 	MuteMetronome bool
+	Metronome MetronomeMix
 	Staff map[*score.Staff]*StaffMix
 	preSolo map[*score.Staff]bool // records Muted status of staves before entering solo
 }
@@ -29,6 +31,101 @@ type StaffMix struct {
 	Muted bool
 }
 
+/* MetronomeMix describes how beats are sounded */
+type MetronomeMix struct {
+	Voice int
+	Pitch uint8
+	Volume float64 // scales the click velocity; 0 to 1
+	BeatsPerBar int // beats in a bar, counted from the first; the first of each is accented. 0 for no accents
+	Subdiv int // clicks per beat (1, 2 for eighths, 3 for triplets, 4 for sixteenths)
+}
+
+/* metronome event resolution; a multiple of every subdivision */
+const metroTicks = 12
+
+var metroVoices = []int{midi.InstWoodblock, midi.InstAgogo, midi.InstTinkleBell, midi.InstMelodicTom}
+
+func DefaultMetronome() MetronomeMix {
+	return MetronomeMix{midi.InstWoodblock, midi.PitchF6, 1.0, 4, 1}
+}
+
+/* Velocity gives the velocity of a click at the given tick (of metroTicks)
+ * within a beat, or 0 if there is no click there. */
+func (mm MetronomeMix) Velocity(beat, tick int) uint8 {
+	subdiv := mm.Subdiv
+	if subdiv < 1 || metroTicks % subdiv != 0 {
+		subdiv = 1
+	}
+	if tick % (metroTicks / subdiv) != 0 {
+		return 0
+	}
+	v := 110.0
+	if tick != 0 {
+		v = 70.0
+	} else if mm.BeatsPerBar > 1 && beat % mm.BeatsPerBar == 0 {
+		v = 127.0
+	}
+	v *= mm.Volume
+	if v < 1 {
+		return 0
+	}
+	return uint8(v)
+}
+
+/* NextVoice cycles through the instruments suited to a click */
+func (mm *MetronomeMix) NextVoice() {
+	for i, v := range metroVoices {
+		if v == mm.Voice {
+			mm.Voice = metroVoices[(i + 1) % len(metroVoices)]
+			return
+		}
+	}
+	mm.Voice = metroVoices[0]
+}
+
+/* NextSubdiv cycles between clicking beats, eighths, triplets and sixteenths */
+func (mm *MetronomeMix) NextSubdiv() {
+	switch mm.Subdiv {
+	case 1:
+		mm.Subdiv = 2
+	case 2:
+		mm.Subdiv = 3
+	case 3:
+		mm.Subdiv = 4
+	default:
+		mm.Subdiv = 1
+	}
+}
+
+/* NextBar cycles the accents between none and 2, 3, 4 or 6 beats to a bar */
+func (mm *MetronomeMix) NextBar() {
+	switch mm.BeatsPerBar {
+	case 0, 1:
+		mm.BeatsPerBar = 2
+	case 2:
+		mm.BeatsPerBar = 3
+	case 3:
+		mm.BeatsPerBar = 4
+	case 4:
+		mm.BeatsPerBar = 6
+	default:
+		mm.BeatsPerBar = 0
+	}
+}
+
+func (mm MetronomeMix) String() string {
+	bar := "no accents"
+	if mm.BeatsPerBar > 1 {
+		bar = fmt.Sprintf("%d/bar", mm.BeatsPerBar)
+	}
+	subdiv := [...]string{"", "beats", "eighths", "triplets", "sixteenths"}
+	s := "beats"
+	if mm.Subdiv >= 1 && mm.Subdiv < len(subdiv) {
+		s = subdiv[mm.Subdiv]
+	}
+	return fmt.Sprintf("%s %s %s %s %.0f%%", midi.InstName(mm.Voice), midi.PitchName(mm.Pitch), bar, s, 100 * mm.Volume)
+}
+
 var Mixer MixConfig
 
 func init() {
@@ -36,6 +133,7 @@ func init() {
 	Mixer.Master.Gain = 1.0
 	Mixer.Midi.Gain = 1.0
 	Mixer.Wave.Gain = 1.0
+	Mixer.Metronome = DefaultMetronome()
 }
 
 func (m *MixConfig) LoadStaff(staff *score.Staff, saved SavedStaff) {
@@ -119,6 +217,12 @@ func (m *MixWidget) Toggle(mute *bool) {
 	m.refresh <- m
 }
 
+/* Metronome applies a change to the metronome settings */
+func (m *MixWidget) Metronome(change func(*MetronomeMix)) {
+	change(&Mixer.Metronome)
+	m.refresh <- m
+}
+
 func (m *MixWidget) AdjustGain(gain *float64, δ float64) {
 	(*gain) += δ
 	m.refresh <- m
@@ -169,6 +273,10 @@ func drawvol(dst draw.Image, layout VolLayout, vol MixVolume, icon *image.Alpha,
 		p = 0.97
 	}
 	draw.Draw(dst, layout.r, &image.Uniform{bg}, image.ZP, draw.Src)
+	if level > 1.0 {
+		/* over full scale; the limiter is working */
+		level = 1.0
+	}
 	if level != 0 {
 		lev := layout.r.Inset(1)
 		lev.Max.X = lev.Min.X + int(float64(lev.Dx())*level)
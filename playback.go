@@ -1,24 +1,33 @@
 package main
 
 import (
-	"math"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/sqweek/sqribe/audio"
 	"github.com/sqweek/sqribe/log"
-	"github.com/sqweek/sqribe/midi"
 	"github.com/sqweek/sqribe/score"
 
 	. "github.com/sqweek/sqribe/core/types"
 )
 
 type Samples struct {
-	buf []int16
+	buf []float32
 	frame, f0, fN FrameN
+	gen int // the transport's seek generation when fetched
+	seek, wrap bool // frame doesn't follow on from the previous buffer
+	last bool // end of the range, when not looping
+	rng TimeRange // the loop, as of fetching
+	pass int // of the loop, counting from 0
+	speed float64 // to play at
+	pos float64 // where the samples begin in the stream fed to the stretcher
 }
 
 type BeatEv struct {
 	Frame FrameN
+	Beat int // index of the beat in the score
+	Tick int // position within the beat, in metroTicks
 	Next *BeatEv
 }
 
@@ -35,13 +44,119 @@ type MidiEv struct {
 	Next *MidiEv
 }
 
-func midilst(f0, fN, fcur FrameN) (*MidiEv, *MidiEv) {
+/* noteSet holds the only notes allowed to sound, when soloing the selection
+ * or auditioning. A nil set lets everything through. */
+type noteSet map[*score.Note]bool
+
+/* notes selected when solo was switched on, or nil */
+var soloed struct {
+	sync.Mutex
+	selection noteSet
+}
+
+func mkNoteSet(notes []score.StaffNote) noteSet {
+	set := make(noteSet, len(notes))
+	for _, sn := range notes {
+		set[sn.Note] = true
+	}
+	return set
+}
+
+/* soloNotes returns the soloed selection, or nil if not soloing */
+func soloNotes() noteSet {
+	soloed.Lock()
+	defer soloed.Unlock()
+	return soloed.selection
+}
+
+/* toggleSolo switches between playing every note and only the currently
+ * selected ones. With nothing selected there is nothing to solo. */
+func toggleSolo() {
+	soloed.Lock()
+	if soloed.selection != nil {
+		soloed.selection = nil
+	} else if sel := G.ww.SelectedNotes(); len(sel) > 0 {
+		soloed.selection = mkNoteSet(sel)
+	} else {
+		soloed.Unlock()
+		return
+	}
+	solo := soloed.selection
+	soloed.Unlock()
+	if G.transport.State() == PLAYING {
+		G.transport.SetSolo(solo)
+	}
+}
+
+func soloStr() string {
+	soloed.Lock()
+	defer soloed.Unlock()
+	if soloed.selection == nil {
+		return ""
+	}
+	return fmt.Sprintf("solo: %d notes", len(soloed.selection))
+}
+
+/* toggleMute mutes the selected notes, or unmutes them if they're all muted
+ * already. */
+func toggleMute() {
+	notes := G.ww.SelectedNotes()
+	muted := true
+	for _, sn := range notes {
+		muted = muted && sn.Note.Muted
+	}
+	G.score.MuteNotes(!muted, notes...)
+}
+
+/* audition plays the selected notes once, or failing that the next chord
+ * from the cursor, without disturbing the loop. Only those notes sound; the
+ * transport forgets them once the audition stops. */
+func audition() {
+	if G.wav == nil || G.transport.State() != STOPPED {
+		return
+	}
+	notes := G.ww.SelectedNotes()
+	if len(notes) == 0 {
+		chords := score.Chords(G.score.Iter(FrameRange{G.ww.FrameAtCursor(), G.ww.WaveRange().MaxFrame()}))
+		if chords == nil {
+			return
+		}
+		notes, _ = chords()
+	}
+	var f0, fN FrameN = -1, -1
+	for _, sn := range notes {
+		start, _ := G.score.ToFrame(G.score.Beatf(sn.Note))
+		end, _ := G.score.ToFrame(G.score.EndBeatf(sn.Note))
+		if f0 == -1 || start < f0 {
+			f0 = start
+		}
+		if end > fN {
+			fN = end
+		}
+	}
+	if fN <= f0 {
+		return
+	}
+	G.transport.SetSolo(mkNoteSet(notes))
+	G.transport.SetLoop(FrameRange{f0, fN}, false)
+	G.transport.SetLeadIn(0, 0, 0)
+	G.transport.SetPractice(Practice{})
+	G.transport.Seek(f0)
+	G.transport.Play(G.wav)
+}
+
+/* midilst lists the notes from f0 to fN, leaving out muted notes and, if
+ * solo is set, any not in it */
+func midilst(f0, fN, fcur FrameN, solo noteSet) (*MidiEv, *MidiEv) {
 	var evcur, evhead *MidiEv
 	evtail := &evhead
 	next := G.score.Iter(FrameRange{f0, fN})
 	var sn score.StaffNote
 	for next != nil {
 		sn, next = next()
+		if sn.Note.Muted || (solo != nil && !solo[sn.Note]) {
+			continue
+		}
 		start, _ := G.score.ToFrame(G.score.Beatf(sn.Note))
 		end, _ := G.score.ToFrame(G.score.EndBeatf(sn.Note))
 		if end <= f0 {
@@ -69,32 +184,47 @@ func midilst(f0, fN, fcur FrameN) (*MidiEv, *MidiEv) {
 func beatlst(f0, fN, fcur FrameN) (*BeatEv, *BeatEv) {
 	var bcur, bhead *BeatEv
 	btail := &bhead
-	for _, frame := range G.score.BeatFrames() {
+	beats := G.score.BeatFrames()
+	for i, frame := range beats {
 		if frame < f0 {
 			continue
 		} else if frame > fN {
 			break
 		}
-		*btail = &BeatEv{frame, nil}
-		if frame > fcur && bcur == nil {
-			bcur = *btail
+		/* an event for every metronome tick, so subdivisions can be changed
+		 * during playback; ticks between the last beat and fN are dropped */
+		for tick := 0; tick < metroTicks; tick++ {
+			f := frame
+			if tick > 0 {
+				if i + 1 >= len(beats) {
+					break
+				}
+				f += (beats[i+1] - frame) * FrameN(tick) / metroTicks
+				if f > fN {
+					break
+				}
+			}
+			*btail = &BeatEv{f, i, tick, nil}
+			if f > fcur && bcur == nil {
+				bcur = *btail
+			}
+			btail = &((*btail).Next)
 		}
-		btail = &((*btail).Next)
 	}
 	return bhead, bcur
 }
 
 // linear interpolation between 'from' -> zero -> 'to'
-func crossfade(from, to []int16, steps FrameN) []int16 {
+func crossfade(from, to []float32, steps FrameN) []float32 {
 	nchan := FrameN(len(from))
-	out := make([]int16, nchan*steps)
+	out := make([]float32, nchan*steps)
 	for i := FrameN(0); i < steps; i++ {
 		α := 1.0 - float64(i + 1)/float64(steps + 1)
 		for j := FrameN(0); j < nchan; j++ {
 			if α > 0.5 {
-				out[nchan*i + j] = int16(float64(from[j]) * 2 * (α - 0.5))
+				out[nchan*i + j] = float32(float64(from[j]) * 2 * (α - 0.5))
 			} else {
-				out[nchan*i + j] = int16(float64(to[j]) * 2 * (0.5 - α))
+				out[nchan*i + j] = float32(float64(to[j]) * 2 * (0.5 - α))
 			}
 		}
 	}
@@ -150,225 +280,115 @@ func coalesce(in chan interface{}, out chan PlayChange) {
 	}
 }
 
-const (
-	STOPPED = iota
-	PLAYING
-	STOPPING
-)
-
-/* globally mutable state... that's not thinking with channels :S */
-var playState int = STOPPED
-
 func playToggle() {
-	switch playState {
-	case PLAYING:
+	if G.transport.State() != STOPPED {
 		log.AU.Println("stopping playback")
-		playState = STOPPING
+		G.transport.Stop() /* does nothing if already stopping */
 		return
-	case STOPPING:
-		return /* in transition; do nothing */
 	}
-
 	if G.wav == nil {
 		return
 	}
-	playState = PLAYING
+	G.transport.SetSolo(soloNotes())
 	rng, loop := G.ww.SelectedTimeRange(), true
 	if rng.MinFrame() >= rng.MaxFrame() {
 		rng, loop = G.ww.WaveRange(), false
 	}
-	cursor := G.ww.FrameAtCursor()
-	if cursor < rng.MinFrame() || cursor > rng.MaxFrame() {
-		cursor = rng.MinFrame()
+	G.transport.SetLoop(rng, loop)
+	preRoll := G.wav.FrameAtTime(time.Duration(Cfg.Playback.PreRollMs) * time.Millisecond)
+	G.transport.SetLeadIn(Cfg.Playback.CountIn, beatSpacing(rng.MinFrame()), preRoll)
+	G.transport.SetPractice(practice())
+	G.transport.Seek(G.ww.FrameAtCursor())
+	G.transport.Play(G.wav)
+}
+
+var practiceMode PracticeMode
+
+/* practice builds the transport's practice routine from the config */
+func practice() Practice {
+	p := Practice{Mode: practiceMode, Passes: 4, Speed: 0.7, Step: 0.1, Advance: advanceLoop}
+	cfg := Cfg.Playback.Practice
+	if cfg.Passes > 0 {
+		p.Passes = cfg.Passes
+	}
+	if cfg.StartPercent > 0 && cfg.StartPercent <= 100 {
+		p.Speed = float64(cfg.StartPercent) / 100
 	}
-	play(rng, cursor, loop)
+	if cfg.StepPercent > 0 {
+		p.Step = float64(cfg.StepPercent) / 100
+	}
+	if p.Mode == PracticeAdvance {
+		p.Speed = 1
+	}
+	return p
 }
 
-func play(rng TimeRange, startPos FrameN, loop bool) {
-	log.AU.Println("starting loop", rng.MinFrame(), rng.MaxFrame(), " @", startPos)
+/* advanceLoop moves a loop of beats on to the following beats */
+func advanceLoop(rng TimeRange) TimeRange {
+	br, ok := rng.(score.BeatRange)
+	if !ok {
+		return rng
+	}
+	return G.score.Shunt(br, br.Last.Subtract(br.First))
+}
 
-	/* wave sample prefetch thread */
-	sampch := make(chan Samples, 25)
-	go func() {
-		bufsiz := FrameN(2048) // must be multiple of 64
-		var s Samples
-		s.frame = startPos
-		for playState == PLAYING {
-			/* re-evaluate f0/fN each iteration in case a bounding beat moves */
-			s.f0, s.fN = rng.MinFrame(), rng.MaxFrame()
-			if s.frame + bufsiz > s.fN {
-				// pad to nearest 64th frame, minimum 20 frames
-				nfPad := 19 + (64 - ((s.fN - s.frame + 1) + 19) % 64)
-				wave := G.wav.Frames(s.frame, s.fN)
-				frame0 := G.wav.Frames(s.f0, s.f0)
-				s.buf = make([]int16, len(wave) + int(nfPad)*len(frame0))
-				copy(s.buf, wave)
-				copy(s.buf[len(wave):], crossfade(wave[len(wave) - len(frame0):], frame0, nfPad))
-				if !loop {
-					playState = STOPPING
-				}
-			} else {
-				s.buf = G.wav.Frames(s.frame, s.frame + bufsiz - 1)
-			}
-			nf := G.wav.ToFrame(SampleN(len(s.buf)))
-			sampch <- s
-			s.frame += nf
-			if s.frame >= s.fN {
-				s.frame = s.f0
-			}
-		}
-		close(sampch)
-	}()
-	if err := audio.Play(startPos); err != nil {
-		log.AU.Println("couldn't start stream:", err)
-		playState = STOPPED
-		return
+/* cyclePractice switches between normal playback, speeding up and advancing */
+func cyclePractice() {
+	practiceMode = (practiceMode + 1) % 3
+	G.transport.SetPractice(practice())
+}
+
+func practiceStr() string {
+	var mode string
+	switch practiceMode {
+	case PracticeSpeedUp:
+		mode = "speed-up"
+	case PracticeAdvance:
+		mode = "advance"
+	default:
+		return ""
+	}
+	if G.transport.State() == STOPPED {
+		return "practice: " + mode
 	}
-	scorechan := make(chan PlayChange)
-	G.plumb.score.Sub(&playState, coalesced(scorechan))
+	p, n := G.transport.Pass(), practice().Passes
+	return fmt.Sprintf("practice: %s, pass %d (%d/%d) @ %.0f%%", mode, p.Pass + 1, p.Pass % n + 1, n, 100 * p.Speed)
+}
 
-	var mpeak, wpeak float64 = 0, 0
-	/* synth & sample feeding thread */
-	go func() {
-		var in Samples
-		var cutoff FrameN
-		woodblock := Synth.Inst(midi.InstWoodblock)
-		bhead, bev := beatlst(rng.MinFrame(), rng.MaxFrame(), startPos)
-		bon := false
-		nf := FrameN(64)
-		bufsiz := int(G.wav.ToSample(nf))
-		mbuf := make([]int16, bufsiz)
-		evhead, mev := midilst(rng.MinFrame(), rng.MaxFrame(), startPos)
-		offlist := make([]MidiOff, 0, 32)
-		for playState == PLAYING {
-			if len(in.buf) == 0 {
-				prevframe := in.frame
-				in = <-sampch
-				if len(in.buf) < bufsiz || len(in.buf) % bufsiz != 0 {
-					log.AU.Println("stopping: prefetch samples sent in non-64 frame multiple", len(in.buf))
-					playState = STOPPING
-					break
-				}
-				select {
-				case changed := <-scorechan:
-					start := time.Now()
-					if changed.beat {
-						bhead, bev = beatlst(in.f0, in.fN, in.frame)
-					}
-					if changed.note || changed.beat {
-						evhead, mev = midilst(in.f0, in.fN, in.frame)
-					}
-					log.AU.Printf("playback change processed in %v (beats:%t notes:%t)", time.Now().Sub(start), changed.beat, changed.note)
-				default:
-				}
-				if prevframe > in.frame {
-					/* we just looped back around */
-					mev = evhead
-					bev = bhead
-					audio.Play(in.frame)
-				}
-				cutoff = in.frame
-			}
-			buf := in.buf[:bufsiz]
-			in.buf = in.buf[bufsiz:]
-			cutoff += nf
-
-			/* turn notes off first so notes at the same pitch directly following
-			** one another don't get truncated */
-			for j := len(offlist) - 1; j >= 0; j-- {
-				// XXX sorted list might be simpler?
-				if offlist[j].End < cutoff {
-					Synth.NoteOff(offlist[j].Chan, offlist[j].Pitch)
-					if j == len(offlist) - 1 {
-						offlist = offlist[:j]
-					} else {
-						copy(offlist[j:], offlist[j+1:])
-					}
-				}
-			}
-			/* metronome */
-			if bon {
-				Synth.NoteOff(woodblock, midi.PitchF6)
-				bon = false
-			} else if !Mixer.MuteMetronome {
-				for bev != nil && bev.Frame < cutoff {
-					if !bon {
-						Synth.NoteOn(woodblock, midi.PitchF6, 120)
-						bon = true
-					}
-					bev = bev.Next
-				}
-			}
-			/* user placed notes */
-			for mev != nil && mev.Start < cutoff {
-				if !mev.Mix.Muted {
-					mev.Off.Chan = Synth.Inst(uint8(mev.Mix.Voice))
-					Synth.NoteOn(mev.Off.Chan, mev.Off.Pitch, uint8(mev.Mix.Velocity))
-					offlist = append(offlist, mev.Off)
-				}
-				mev = mev.Next
-			}
+/* beatSpacing estimates the time between beats around frame f, for
+ * extrapolating a count-in. It returns 0 if there aren't enough beats. */
+func beatSpacing(f FrameN) FrameN {
+	beats := G.score.BeatFrames()
+	i := sort.Search(len(beats), func(i int) bool { return beats[i] >= f })
+	if i > len(beats) - 2 {
+		i = len(beats) - 2 // past the last beat; use the last gap
+	}
+	if i < 0 {
+		return 0
+	}
+	return beats[i+1] - beats[i]
+}
 
-			Synth.WriteFrames(mbuf)
-			α, β := 0.0, 0.0
-			if !Mixer.Wave.Muted {
-				α = Mixer.Wave.Gain
-			}
-			if !Mixer.Midi.Muted {
-				β = Mixer.Midi.Gain
-			}
-			γ := Mixer.Master.Gain
-			agc := 1.0
-			for j := 0; j < bufsiz; j++ {
-				w, m := γ * α * float64(buf[j]), γ * β * float64(mbuf[j])
-				wpeak = math.Max(wpeak, math.Abs(w))
-				mpeak = math.Max(mpeak, math.Abs(m))
-				if math.Abs(agc*(w + m)) > 32700 {
-					f := math.Abs(w + m) / 32700
-					for k := j - 1; k >= 0; k-- {
-						mbuf[k] = int16(float64(mbuf[k]) / f)
-					}
-					agc /= f
-				}
-				mbuf[j] = int16(agc*(w + m))
-			}
-			if agc != 1.0 {
-				Mixer.Master.Gain = γ * agc
-			}
-			audio.Append(mbuf)
-		}
-		for _, ev := range(offlist) {
-			Synth.NoteOff(ev.Chan, ev.Pitch)
-		}
-		for _ = range(sampch) {
-			// drain channel
-		}
-		G.plumb.score.Unsub(&playState)
-		audio.Stop()
-		playState = STOPPED
-	}()
-	//TODO wait for ring buffer to fill up a bit before kicking off audio
-	/* gui feedback thread */
+/* watchTransport keeps the cursor, level meters and practice counter up to
+ * date during playback */
+func watchTransport(redraw chan Widget) {
+	events := make(chan interface{})
+	G.plumb.transport.Sub(G.transport, events)
 	go func() {
-		for {
-			f, playing := audio.PlayingFrame()
-			if !playing {
-				if playState == PLAYING && f != 0 {
-					/* we think we're playing, but the audio callback hasn't
-					 * run for awhile. just stop. */
-					log.AU.Println("stopping: lost audio callback")
-					playState = STOPPING
+		for ev := range events {
+			switch ev := ev.(type) {
+			case TransportPos:
+				G.ww.SetCursorByFrame(ev.Frame, ev.Follow)
+				G.mixw.Levels(ev.MidiPeak, ev.WavePeak)
+			case TransportPass:
+				if br, ok := ev.Range.(score.BeatRange); ok && ev.Range != G.ww.SelectedTimeRange() {
+					/* practice has moved the loop on */
+					G.ww.SelectAudio(br)
 				}
-				break
+				redraw <- nil
+			case TransportState:
+				redraw <- nil
 			}
-			G.ww.SetCursorByFrame(f, !loop)
-			m, w := mpeak, wpeak
-			mpeak, wpeak = 0, 0
-			G.mixw.Levels(m/32700, w/32700)
-			time.Sleep(66 * time.Millisecond)
 		}
 	}()
 }
-
-
-
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/sqweek/sqribe/dsp"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/midi"
+	"github.com/sqweek/sqribe/score"
+	"github.com/sqweek/sqribe/wave"
+)
+
+const (
+	polyMinPitch = 36 // C3 in sqribe's octave numbering
+	polyMaxPitch = 96
+	polyWindow = 4096 // frames per analysis window
+	polyMaxFreq = 5000.0 // spectrum bins above this are ignored
+	polySubdiv = 4 // analysis segments per beat
+	polyMaxVoices = 6 // most simultaneous pitches reported per segment
+	polyThreshold = 0.15 // activation relative to the loudest, below which a pitch is ignored
+	polyIters = 60
+)
+
+/* PitchTemplates holds the expected spectrum of each pitch on [polyMinPitch, polyMaxPitch] */
+type PitchTemplates struct {
+	w [][]float64
+	nbins int
+}
+
+func (pt *PitchTemplates) pitch(i int) uint8 {
+	return uint8(polyMinPitch + i)
+}
+
+/* templateBins is the number of spectrum bins up to polyMaxFreq, or all of
+ * them if the recording's nyquist frequency is lower */
+func templateBins(rate int) int {
+	n := int(polyMaxFreq / (float64(rate) / polyWindow))
+	if n > polyWindow / 2 {
+		n = polyWindow / 2
+	}
+	return n
+}
+
+/* normalises a template so that each pitch contributes equal energy */
+func (pt *PitchTemplates) add(spec dsp.Spectrum) {
+	t := make([]float64, pt.nbins)
+	copy(t, spec.Mag)
+	sum := 0.0
+	for _, x := range t {
+		sum += x
+	}
+	if sum > 0 {
+		for k := range t {
+			t[k] /= sum
+		}
+	}
+	pt.w = append(pt.w, t)
+}
+
+/* HarmonicTemplates synthesises a decaying harmonic series for each pitch */
+func HarmonicTemplates(rate int) *PitchTemplates {
+	pt := &PitchTemplates{nbins: templateBins(rate)}
+	for p := polyMinPitch; p <= polyMaxPitch; p++ {
+		f0 := CentsToFreq(float64(p * 100) + Synth.Tuning())
+		x := make([]float64, polyWindow)
+		for k := 1; k <= 12 && float64(k) * f0 < polyMaxFreq; k++ {
+			ω := 2 * math.Pi * float64(k) * f0 / float64(rate)
+			for i := range x {
+				x[i] += math.Sin(ω * float64(i)) / float64(k)
+			}
+		}
+		pt.add(dsp.MkSpectrum(x, rate))
+	}
+	return pt
+}
+
+/* SoundFontTemplates renders each pitch with the given instrument from the soundfont */
+func SoundFontTemplates(rate int, inst uint8) (*PitchTemplates, error) {
+	synth, err := SynthInit(rate, Synth.SoundFont())
+	if err != nil {
+		return nil, err
+	}
+	defer synth.Delete()
+	synth.SetTuning(Synth.Tuning())
+	skip := rate / 20 // ignore the attack transient
+	pt := &PitchTemplates{nbins: templateBins(rate)}
+	for p := polyMinPitch; p <= polyMaxPitch; p++ {
+		buf := synth.Render(inst, uint8(p), 100, skip + polyWindow)
+		pt.add(dsp.MkSpectrum(dsp.Mono(buf[2*skip:], 2), rate))
+	}
+	return pt, nil
+}
+
+/* staffForPitch assigns pitches to treble/bass staves if both exist, otherwise to the default staff */
+func staffForPitch(sc *score.Score, def *score.Staff) func(uint8) *score.Staff {
+	var treble, bass *score.Staff
+	for _, staff := range sc.Staves() {
+		if staff.Clef() == &score.TrebleClef && treble == nil {
+			treble = staff
+		} else if staff.Clef() == &score.BassClef && bass == nil {
+			bass = staff
+		}
+	}
+	if treble == nil || bass == nil {
+		return func(uint8) *score.Staff { return def }
+	}
+	return func(pitch uint8) *score.Staff {
+		if pitch >= midi.PitchC5 {
+			return treble
+		}
+		return bass
+	}
+}
+
+type polyNote struct {
+	note *score.Note
+	staff *score.Staff
+	conf float64 // peak activation over the note's lifetime
+}
+
+/* SuggestChords estimates the pitches sounding in each subdivision of the
+ * selected beats by factorising the spectrum against per-pitch templates. */
+func SuggestChords(wav *wave.Waveform, sc *score.Score, def *score.Staff, br score.BeatRange, templates *PitchTemplates) (*Suggestion, error) {
+	rate := wav.Rate()
+	activations := make([][]float64, 0, 64)
+	type segment struct {
+		beat *score.BeatRef
+		i int // subdivision index within the beat
+	}
+	segs := make([]segment, 0, 64)
+	hmax := 0.0
+	for b := br.First; b != nil && b.Frame() < br.Last.Frame(); b = b.Next() {
+		for i := 0; i < polySubdiv; i++ {
+			mid := b.FrameAt((float64(i) + 0.5) / polySubdiv)
+			f0 := mid - polyWindow/2
+			fN := f0 + polyWindow - 1
+			if wav.ClipFrame(f0) != f0 || wav.ClipFrame(fN) != fN {
+				/* a shorter window wouldn't match the templates' bins */
+				continue
+			}
+			/* always a full window, so the spectrum has the templates' bins */
+			x := make([]float64, polyWindow)
+			copy(x, dsp.Mono(wav.Frames(f0, fN), wav.Channels))
+			spec := dsp.MkSpectrum(x, rate)
+			h := dsp.Activations(spec.Mag[:templates.nbins], templates.w, polyIters)
+			for _, x := range h {
+				hmax = math.Max(hmax, x)
+			}
+			activations = append(activations, h)
+			segs = append(segs, segment{b, i})
+		}
+	}
+	if hmax == 0 {
+		return nil, fmt.Errorf("no pitched content found")
+	}
+	staffFor := staffForPitch(sc, def)
+	sounding := make(map[int]*polyNote) // template index -> note currently sounding
+	sugg := &Suggestion{}
+	finish := func(i int) {
+		if pn := sounding[i]; pn != nil {
+			sugg.add(pn.staff, pn.note, pn.conf)
+			delete(sounding, i)
+		}
+	}
+	for j, h := range activations {
+		active := topActive(h, hmax)
+		for i := range sounding {
+			if _, ok := active[i]; !ok {
+				finish(i)
+			}
+		}
+		seg := segs[j]
+		for i, conf := range active {
+			if pn := sounding[i]; pn != nil {
+				pn.note.Duration.Add(pn.note.Duration, big.NewRat(1, polySubdiv))
+				pn.conf = math.Max(pn.conf, conf)
+				continue
+			}
+			pitch := templates.pitch(i)
+			note := &score.Note{pitch, big.NewRat(1, polySubdiv), seg.beat, big.NewRat(int64(seg.i), polySubdiv), false}
+			sounding[i] = &polyNote{note, staffFor(pitch), conf}
+		}
+	}
+	for i := range sounding {
+		finish(i)
+	}
+	for _, sn := range sugg.Notes {
+		sn.Note.Duration = snapDuration(sn.Note.Duration)
+	}
+	return sugg, nil
+}
+
+/* topActive returns the strongest activations above threshold, scaled to [0,1] */
+func topActive(h []float64, hmax float64) map[int]float64 {
+	active := make(map[int]float64)
+	for n := 0; n < polyMaxVoices; n++ {
+		best := -1
+		for i, x := range h {
+			if _, ok := active[i]; !ok && x / hmax >= polyThreshold && (best == -1 || x > h[best]) {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+		active[best] = math.Min(1, h[best] / hmax)
+	}
+	return active
+}
+
+/* suggestChords runs polyphonic detection over the selected beats in the background */
+func suggestChords() {
+	wav, sc := G.wav, G.score
+	if wav == nil {
+		return
+	}
+	br, ok := G.ww.SelectedTimeRange().(score.BeatRange)
+	if !ok || br.First == br.Last {
+		alert("chord detection: select a range of beats first")
+		return
+	}
+	staff := suggestStaff(G.ww)
+	if staff == nil {
+		alert("chord detection: add a staff first")
+		return
+	}
+	inst := uint8(Mixer.For(staff).Voice)
+	go func() {
+		var templates *PitchTemplates
+		if Cfg.Analysis.SoundFontTemplates {
+			var err error
+			if templates, err = SoundFontTemplates(wav.Rate(), inst); err != nil {
+				log.UI.Printf("soundfont templates: %v; using harmonic templates", err)
+			}
+		}
+		if templates == nil {
+			templates = HarmonicTemplates(wav.Rate())
+		}
+		sugg, err := SuggestChords(wav, sc, staff, br, templates)
+		if err != nil {
+			alert("%v", err)
+			return
+		}
+		log.UI.Printf("chord detection suggested %d notes", len(sugg.Notes))
+		G.ww.SetSuggestion(sugg)
+	}()
+}
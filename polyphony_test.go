@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sqweek/sqribe/plumb"
+	"github.com/sqweek/sqribe/score"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+/* suggestTone runs SuggestChords over an A4 lasting frames, with four beats
+ * spread across it */
+func suggestTone(t *testing.T, rate, frames int) (*Suggestion, error) {
+	wav, done := testWaveform(t, tone(rate, frames, CentsToFreq(6900), 0.2), rate, 1)
+	defer done()
+	sc := score.MkScore(plumb.MkPort())
+	defer sc.Close()
+	last := FrameN(frames - 1)
+	sc.LoadBeats([]FrameN{0, last / 4, last / 2, 3 * last / 4, last})
+	staff := score.MkStaff("test", &score.TrebleClef, 0)
+	sc.AddStaff(staff)
+	br := score.BeatRange{sc.Head, sc.NearestBeat(last)}
+	return SuggestChords(wav, sc, staff, br, HarmonicTemplates(rate))
+}
+
+func hasPitch(sugg *Suggestion, pitch uint8) bool {
+	for _, sn := range sugg.Notes {
+		if sn.Note.Pitch == pitch {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSuggestChords(t *testing.T) {
+	synth := Synth
+	defer func() { Synth = synth }()
+	Synth = &Synthesizer{}
+
+	/* at 8kHz the spectrum stops short of polyMaxFreq */
+	sugg, err := suggestTone(t, 8000, 2 * 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasPitch(sugg, 69) {
+		t.Errorf("8kHz: A4 not suggested in %d notes", len(sugg.Notes))
+	}
+
+	/* windows running off either end of the file are skipped */
+	sugg, err = suggestTone(t, 22050, 26460)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasPitch(sugg, 69) {
+		t.Errorf("22kHz: A4 not suggested in %d notes", len(sugg.Notes))
+	}
+
+	/* and if every window is clipped there's nothing to go on */
+	if _, err = suggestTone(t, 22050, 1500); err == nil {
+		t.Error("suggested notes from a file shorter than a window")
+	}
+}
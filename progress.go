@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"github.com/sqweek/sqribe/wave"
+)
+
+/* DecodeStatus tracks decoding of the current waveform for the status line */
+type DecodeStatus struct {
+	sync.Mutex
+	active bool
+	fraction float64 // -1 if unknown
+	seconds float64 // audio decoded so far
+}
+
+var decodeStatus DecodeStatus
+
+/* watchDecode follows progress events and requests a redraw of the status line as they arrive */
+func watchDecode(redraw chan Widget) {
+	events := make(chan interface{})
+	G.plumb.decode.Sub(&decodeStatus, events)
+	go func() {
+		for ev := range events {
+			p, ok := ev.(wave.DecodeProgress)
+			if !ok || p.Wave != G.wav {
+				continue // a waveform we've since moved on from
+			}
+			decodeStatus.Lock()
+			decodeStatus.active = !p.Done
+			decodeStatus.fraction = p.Fraction
+			decodeStatus.seconds = p.Wave.TimeAtFrame(p.Wave.ToFrame(p.Samples)).Seconds()
+			decodeStatus.Unlock()
+			redraw <- nil
+		}
+	}()
+}
+
+/* drawProgress draws a progress bar at the right of the status line, returning the space left over */
+func drawProgress(dst draw.Image, r image.Rectangle) image.Rectangle {
+	decodeStatus.Lock()
+	active, fraction, seconds := decodeStatus.active, decodeStatus.fraction, decodeStatus.seconds
+	decodeStatus.Unlock()
+	if !active {
+		return r
+	}
+	barR := image.Rect(r.Max.X - 160, r.Min.Y + 3, r.Max.X - 4, r.Max.Y - 3)
+	border := color.RGBA{0x66, 0x66, 0x66, 0xff}
+	fill := color.RGBA{0x99, 0x99, 0xcc, 0xff}
+	drawBorders(dst, barR, border, color.White)
+	label := fmt.Sprintf("decoding %.0fs", seconds)
+	if fraction >= 0 {
+		inner := barR.Inset(1)
+		inner.Max.X = inner.Min.X + int(fraction * float64(inner.Dx()))
+		draw.Draw(dst, inner, &image.Uniform{fill}, image.ZP, draw.Src)
+		label = fmt.Sprintf("decoding %.0f%%", 100 * fraction)
+	}
+	G.font.luxi.Draw(dst, color.Black, barR.Inset(2), label)
+	r.Max.X = barR.Min.X
+	return r
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/sqweek/sqribe/dsp"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	mixBlock = FrameN(64) // frames rendered at a time; matches fluidsynth's internal block size
+	clickDuration = 60 * time.Millisecond // how long each metronome tick sounds
+)
+
+/* mixdown combines the recording with the placed notes and beat tones,
+ * according to Mixer. Both playback and offline bounces render through it so
+ * what gets shared sounds like what was heard. */
+type mixdown struct {
+	synth *Synthesizer
+	bhead, bev *BeatEv
+	cev *BeatEv // count-in clicks, which sound even if the metronome is muted
+	bon bool
+	bchan, bpitch uint8 // the sounding click
+	boff FrameN // when the sounding click ends
+	clickLen FrameN
+	evhead, mev *MidiEv
+	solo noteSet // the only notes to play, or nil for all
+	offlist []MidiOff
+	mbuf []float32
+	limiter *dsp.Limiter
+
+	wpeak, mpeak float64 // loudest recording/note samples since the meters last looked
+}
+
+func newMixdown(synth *Synthesizer, rate, channels int) *mixdown {
+	return &mixdown{
+		synth: synth,
+		offlist: make([]MidiOff, 0, 32),
+		mbuf: make([]float32, int(mixBlock) * channels),
+		limiter: dsp.NewLimiter(rate, channels),
+		clickLen: FrameN(clickDuration.Seconds() * float64(rate)),
+	}
+}
+
+/* Latency is the number of frames the mix lags behind its input */
+func (md *mixdown) Latency() FrameN {
+	return FrameN(md.limiter.Latency())
+}
+
+/* Seek (re)builds the beat and note lists for the range f0 to fN, ready to
+ * continue rendering from frame. Anything still in the limiter's delay line
+ * is discarded. */
+func (md *mixdown) Seek(f0, fN, frame FrameN) {
+	md.cev = nil
+	md.limiter.Reset()
+	md.Rescore(PlayChange{true, true}, f0, fN, frame)
+}
+
+/* CountIn adds clicks at the given frames, ahead of the range */
+func (md *mixdown) CountIn(frames []FrameN) {
+	tail := &md.cev
+	for i, f := range frames {
+		*tail = &BeatEv{f, i, 0, nil}
+		tail = &((*tail).Next)
+	}
+}
+
+/* Rescore rebuilds whichever event lists are affected by a score change */
+func (md *mixdown) Rescore(changed PlayChange, f0, fN, frame FrameN) {
+	if changed.beat {
+		md.bhead, md.bev = beatlst(f0, fN, frame)
+	}
+	if changed.note || changed.beat {
+		md.evhead, md.mev = midilst(f0, fN, frame, md.solo)
+	}
+}
+
+/* Rewind goes back to the start of the range, for looping */
+func (md *mixdown) Rewind() {
+	md.mev = md.evhead
+	md.bev = md.bhead
+}
+
+/* Mix renders one block. wav holds mixBlock frames of the recording, and
+ * cutoff is the frame following them. The returned slice is reused by the
+ * next call. */
+func (md *mixdown) Mix(wav []float32, cutoff FrameN) []float32 {
+	return md.MixAt(wav, float64(cutoff - mixBlock), 1)
+}
+
+/* MixAt renders one block of the recording played at the given speed, wav
+ * holding mixBlock frames of it from frame src. fluidsynth renders in fixed
+ * blocks of its own (the same size as mixBlock) and only acts on events
+ * between them, so each block starts whichever events fall nearer to its
+ * start than to the next block's. Notes and clicks therefore sound within
+ * half a block (under a millisecond) of their frame. */
+func (md *mixdown) MixAt(wav []float32, src, speed float64) []float32 {
+	mbuf := md.mbuf
+	md.trigger(FrameN(math.Floor(src + float64(mixBlock) / 2 * speed)) - 1)
+	md.synth.WriteFloat(mbuf)
+
+	α, β := 0.0, 0.0
+	if !Mixer.Wave.Muted {
+		α = Mixer.Wave.Gain
+	}
+	if !Mixer.Midi.Muted {
+		β = Mixer.Midi.Gain
+	}
+	γ := Mixer.Master.Gain
+	for j := range mbuf {
+		w, m := γ * α * float64(wav[j]), γ * β * float64(mbuf[j])
+		md.wpeak = math.Max(md.wpeak, math.Abs(w))
+		md.mpeak = math.Max(md.mpeak, math.Abs(m))
+		mbuf[j] = float32(w + m)
+	}
+	/* the mix has headroom above full scale; the limiter brings
+	 * any peaks back in range before conversion to the output format */
+	md.limiter.Process(mbuf)
+	return mbuf
+}
+
+/* Flush returns the last Latency() frames still held by the limiter, after
+ * which the mix has caught up with its input. */
+func (md *mixdown) Flush() []float32 {
+	nc := len(md.mbuf) / int(mixBlock)
+	tail := make([]float32, int(md.Latency()) * nc)
+	md.limiter.Process(tail)
+	return tail
+}
+
+/* trigger sends the synth every event due at or before frame */
+func (md *mixdown) trigger(frame FrameN) {
+	synth := md.synth
+	/* turn notes off first so notes at the same pitch directly following
+	** one another don't get truncated */
+	for j := len(md.offlist) - 1; j >= 0; j-- {
+		// XXX sorted list might be simpler?
+		if md.offlist[j].End <= frame {
+			synth.NoteOff(md.offlist[j].Chan, md.offlist[j].Pitch)
+			if j == len(md.offlist) - 1 {
+				md.offlist = md.offlist[:j]
+			} else {
+				copy(md.offlist[j:], md.offlist[j+1:])
+				md.offlist = md.offlist[:len(md.offlist) - 1]
+			}
+		}
+	}
+	/* metronome */
+	if md.bon && md.boff <= frame {
+		synth.NoteOff(md.bchan, md.bpitch)
+		md.bon = false
+	}
+	metro := Mixer.Metronome
+	var vel uint8
+	for md.bev != nil && md.bev.Frame <= frame {
+		/* clicks which are already behind us (eg. after a seek) only sound once */
+		if v := metro.Velocity(md.bev.Beat, md.bev.Tick); v > vel && !Mixer.MuteMetronome {
+			vel = v
+		}
+		md.bev = md.bev.Next
+	}
+	for md.cev != nil && md.cev.Frame <= frame {
+		if v := metro.Velocity(md.cev.Beat, md.cev.Tick); v > vel {
+			vel = v
+		}
+		md.cev = md.cev.Next
+	}
+	if vel > 0 {
+		if md.bon {
+			synth.NoteOff(md.bchan, md.bpitch)
+		}
+		md.bchan, md.bpitch = synth.Inst(uint8(metro.Voice)), metro.Pitch
+		synth.NoteOn(md.bchan, md.bpitch, vel)
+		md.bon, md.boff = true, frame + md.clickLen
+	}
+	/* user placed notes */
+	for md.mev != nil && md.mev.Start <= frame {
+		if !md.mev.Mix.Muted {
+			md.mev.Off.Chan = synth.Inst(uint8(md.mev.Mix.Voice))
+			synth.NoteOn(md.mev.Off.Chan, md.mev.Off.Pitch, uint8(md.mev.Mix.Velocity))
+			md.offlist = append(md.offlist, md.mev.Off)
+		}
+		md.mev = md.mev.Next
+	}
+}
+
+/* Stop silences any notes still sounding */
+func (md *mixdown) Stop() {
+	for _, ev := range md.offlist {
+		md.synth.NoteOff(ev.Chan, ev.Pitch)
+	}
+	md.offlist = md.offlist[:0]
+	if md.bon {
+		md.synth.NoteOff(md.bchan, md.bpitch)
+		md.bon = false
+	}
+}
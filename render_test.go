@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sqweek/sqribe/plumb"
+	"github.com/sqweek/sqribe/score"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+/* TestBounceOnset renders a note off the block grid and checks when it
+ * starts. It needs a real soundfont, named by $SQRIBE_SOUNDFONT. */
+func TestBounceOnset(t *testing.T) {
+	sfont := os.Getenv("SQRIBE_SOUNDFONT")
+	if _, err := os.Stat(sfont); sfont == "" || err != nil {
+		t.Skip("set SQRIBE_SOUNDFONT to a soundfont to test rendering")
+	}
+	rate := 44100
+	synth, muted, sc := Synth, Mixer.MuteMetronome, G.score
+	defer func() { Synth, Mixer.MuteMetronome, G.score = synth, muted, sc }()
+	var err error
+	if Synth, err = SynthInit(rate, sfont); err != nil {
+		t.Fatal(err)
+	}
+	defer Synth.Delete()
+	Mixer.MuteMetronome = true
+	wav, done := testWaveform(t, make([]float64, rate), rate, 2)
+	defer done()
+	G.score = score.MkScore(plumb.MkPort())
+	defer G.score.Close()
+	G.score.LoadBeats([]FrameN{0, 11025, 22050, 33075, 44099})
+	staff := score.MkStaff("test", &score.TrebleClef, 0)
+	G.score.AddStaff(staff)
+	/* a third of the way into the second beat: frame 14700, mid block */
+	onset := FrameN(14700)
+	G.score.AddNotes(staff, &score.Note{69, big.NewRat(1, 1), G.score.Head.Next(), big.NewRat(1, 3), false})
+
+	dir, err := ioutil.TempDir("", "sqribe-bounce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mix.wav")
+	if err := Bounce(wav, FrameRange{0, 44099}, file); err != nil {
+		t.Fatal(err)
+	}
+	src, err := wave.OpenSource(file, rate, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	heard := FrameN(-1)
+	for f := FrameN(0); heard < 0; {
+		samps, err := src.Read()
+		if err != nil {
+			t.Fatal("note never sounded: ", err)
+		}
+		for i := 0; i < len(samps); i += 2 {
+			if math.Abs(float64(samps[i])) > 8 {
+				heard = f + FrameN(i / 2)
+				break
+			}
+		}
+		f += FrameN(len(samps) / 2)
+	}
+	if heard < onset - mixBlock / 2 || heard > onset + mixBlock / 2 {
+		t.Errorf("note heard at frame %d, expected within half a block of %d", heard, onset)
+	}
+}
@@ -173,6 +173,51 @@ func (op *MvBeatOp) undo(score *Score) {
 	op.beat.frame = op.old
 }
 
+/* ShiftBeats moves the beats from br.First to br.Last inclusive (or every
+ * beat, if br is nil) by Δf frames. Notes move with their beats. Nothing
+ * happens if a beat would pass one outside the range or go before 0. */
+func (score *Score) ShiftBeats(br *BeatRange, Δf FrameN) bool {
+	return score.update(&ShiftBeatsOp{br: br, Δf: Δf})
+}
+
+type ShiftBeatsOp struct {
+	br *BeatRange
+	Δf FrameN
+	first, last *BeatRef
+}
+
+func (op *ShiftBeatsOp) apply(score *Score) interface{} {
+	first, last := score.Head, score.Tail
+	if op.br != nil {
+		first, last = op.br.First, op.br.Last
+	}
+	if op.Δf == 0 || first == nil || first.frame + op.Δf < 0 {
+		return nil
+	}
+	if p := first.prev; p != nil && first.frame + op.Δf <= p.frame {
+		return nil
+	}
+	if n := last.next; n != nil && last.frame + op.Δf >= n.frame {
+		return nil
+	}
+	op.first, op.last = first, last
+	op.shift(op.Δf)
+	return BeatChanged{}
+}
+
+func (op *ShiftBeatsOp) shift(Δf FrameN) {
+	for b := op.first; b != nil; b = b.next {
+		b.frame += Δf
+		if b == op.last {
+			break
+		}
+	}
+}
+
+func (op *ShiftBeatsOp) undo(score *Score) {
+	op.shift(-op.Δf)
+}
+
 func (beats *BeatList) ToFrame(pt BeatPoint) (FrameN, bool) {
 	b := pt.Beat()
 	b2 := b.next
@@ -0,0 +1,49 @@
+package score
+
+import (
+	"testing"
+
+	"github.com/sqweek/sqribe/plumb"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+func checkBeats(t *testing.T, score *Score, want ...FrameN) {
+	got := score.BeatFrames()
+	if len(got) != len(want) {
+		t.Fatalf("beats %v, expected %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("beats %v, expected %v", got, want)
+		}
+	}
+}
+
+func TestShiftBeats(t *testing.T) {
+	score := MkScore(plumb.MkPort())
+	defer score.Close()
+	score.LoadBeats([]FrameN{1000, 2000, 3000, 4000})
+
+	if !score.ShiftBeats(nil, -500) {
+		t.Fatal("shifting all beats failed")
+	}
+	checkBeats(t, score, 500, 1500, 2500, 3500)
+
+	br := BeatRange{score.Head.next, score.Head.next.next}
+	if score.ShiftBeats(&br, 1000) {
+		t.Error("shifted beats past the following beat")
+	}
+	if score.ShiftBeats(nil, -600) {
+		t.Error("shifted beats before zero")
+	}
+	if !score.ShiftBeats(&br, 200) {
+		t.Fatal("shifting selected beats failed")
+	}
+	checkBeats(t, score, 500, 1700, 2700, 3500)
+
+	score.Undo()
+	checkBeats(t, score, 500, 1500, 2500, 3500)
+	score.Undo()
+	checkBeats(t, score, 1000, 2000, 3000, 4000)
+}
@@ -0,0 +1,99 @@
+package score
+
+import (
+	"math"
+)
+
+type Mode int
+
+const (
+	Major Mode = iota
+	Minor
+)
+
+/* Key pairs a key signature with its mode, eg. 0/Minor is A minor */
+type Key struct {
+	Sig KeySig
+	Mode Mode
+}
+
+var minorNames = []string{"Ab", "Eb", "Bb", "F", "C", "G", "D", "A", "E", "B", "F#", "C#", "G#", "D#", "A#"}
+
+func (key Key) String() string {
+	if key.Mode == Minor {
+		if key.Sig < -7 || key.Sig > 7 {
+			return "???"
+		}
+		return minorNames[key.Sig + 7] + " Minor"
+	}
+	return key.Sig.String()
+}
+
+/* Tonic returns the pitch class of the key's root (0 = C) */
+func (key Key) Tonic() int {
+	pc := mod(7 * int(key.Sig), 12)
+	if key.Mode == Minor {
+		pc = mod(pc - 3, 12)
+	}
+	return pc
+}
+
+/* KeyForTonic returns the key with the given root pitch class and mode,
+ * preferring the signature with fewest accidentals. */
+func KeyForTonic(tonic int, mode Mode) Key {
+	if mode == Minor {
+		tonic += 3 // relative major
+	}
+	nsharps := mod(7 * tonic, 12)
+	if nsharps > 6 {
+		nsharps -= 12
+	}
+	return Key{KeySig(nsharps), mode}
+}
+
+/* Krumhansl-Kessler probe tone profiles, starting from the tonic */
+var majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+var minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+
+func correlate(profile, hist [12]float64, tonic int) float64 {
+	var mp, mh float64
+	for i := 0; i < 12; i++ {
+		mp += profile[i] / 12
+		mh += hist[i] / 12
+	}
+	var sxy, sxx, syy float64
+	for i := 0; i < 12; i++ {
+		x := profile[i] - mp
+		y := hist[mod(tonic + i, 12)] - mh
+		sxy += x * y
+		sxx += x * x
+		syy += y * y
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx * syy)
+}
+
+/* EstimateKey finds the key whose Krumhansl-Schmuckler profile best
+ * correlates with a pitch class histogram (index 0 = C). The correlation
+ * coefficient of the winning key is returned alongside it. */
+func EstimateKey(hist [12]float64) (Key, float64) {
+	best, r := Key{}, math.Inf(-1)
+	for tonic := 0; tonic < 12; tonic++ {
+		for mode, profile := range [][12]float64{majorProfile, minorProfile} {
+			if c := correlate(profile, hist, tonic); c > r {
+				best, r = KeyForTonic(tonic, Mode(mode)), c
+			}
+		}
+	}
+	return best, r
+}
+
+/* SetKey changes the key signature of every staff */
+func (score *Score) SetKey(key KeySig) {
+	for _, staff := range score.staves {
+		staff.nsharps = key
+	}
+	score.plumb.C <- KeyChanged(staffChanged(score.staves...))
+}
@@ -0,0 +1,35 @@
+package score
+
+import (
+	"testing"
+)
+
+func TestKeyForTonic(t *testing.T) {
+	for sig := KeySig(-5); sig <= 6; sig++ {
+		for _, mode := range []Mode{Major, Minor} {
+			key := Key{sig, mode}
+			if k := KeyForTonic(key.Tonic(), mode); k != key {
+				t.Errorf("%v: tonic %d maps back to %v", key, key.Tonic(), k)
+			}
+		}
+	}
+}
+
+func TestEstimateKey(t *testing.T) {
+	/* note weights of a simple tune in E minor, leaning on E/G/B */
+	var hist [12]float64
+	for pc, w := range map[int]float64{4: 8, 6: 2, 7: 5, 9: 2, 11: 6, 0: 2, 2: 3} {
+		hist[pc] = w
+	}
+	if key, _ := EstimateKey(hist); key != (Key{1, Minor}) {
+		t.Errorf("expected E Minor, got %v", key)
+	}
+	/* C major scale with emphasis on the triad */
+	hist = [12]float64{}
+	for pc, w := range map[int]float64{0: 8, 2: 3, 4: 6, 5: 3, 7: 7, 9: 3, 11: 2} {
+		hist[pc] = w
+	}
+	if key, _ := EstimateKey(hist); key != (Key{0, Major}) {
+		t.Errorf("expected C Major, got %v", key)
+	}
+}
@@ -19,6 +19,7 @@ type Note struct {
 	Duration *big.Rat
 	Beat *BeatRef
 	Offset *big.Rat
+	Muted bool /* skipped during playback */
 }
 
 type StaffChanged struct {
@@ -187,6 +188,7 @@ func (src *Note) Dup() (dst *Note) {
 	dst.Offset.Set(src.Offset)
 	dst.Pitch = src.Pitch
 	dst.Duration.Set(src.Duration)
+	dst.Muted = src.Muted
 	return dst
 }
 
@@ -202,30 +204,44 @@ func (staff *Staff) removeNote(note *Note) bool {
 }
 
 func (score *Score) AddNotes(staff *Staff, notes... *Note) {
-	score.update(&AddNotesOp{staff, notes, make(map[*Note]*big.Rat)})
+	sns := make([]StaffNote, len(notes))
+	for i, note := range notes {
+		sns[i] = StaffNote{staff, note}
+	}
+	score.update(&AddNotesOp{staffChanged(staff), sns, make(map[*Note]*big.Rat)})
+}
+
+/* AddStaffNotes adds notes across any number of staves as a single undoable op */
+func (score *Score) AddStaffNotes(notes... StaffNote) {
+	if len(notes) == 0 {
+		return
+	}
+	score.update(&AddNotesOp{notesChanged(notes), notes, make(map[*Note]*big.Rat)})
 }
 
 type AddNotesOp struct {
-	staff *Staff
-	notes []*Note
+	changed StaffChanged
+	notes []StaffNote
 	origDur map[*Note]*big.Rat
 }
 
 func (op *AddNotesOp) apply(score *Score) interface{} {
-	for _, note := range op.notes {
-		if existing := op.staff.NoteAt(note); existing != nil {
-			op.origDur[note] = big.NewRat(1, 1)
-			op.origDur[note].Set(existing.Duration)
+	for _, sn := range op.notes {
+		if existing := sn.Staff.NoteAt(sn.Note); existing != nil {
+			op.origDur[sn.Note] = big.NewRat(1, 1)
+			op.origDur[sn.Note].Set(existing.Duration)
 		}
 	}
-	op.staff.addNote(op.notes...)
-	return staffChanged(op.staff)
+	for _, sn := range op.notes {
+		sn.Staff.addNote(sn.Note)
+	}
+	return op.changed
 }
 
 func (op *AddNotesOp) undo(score *Score) {
-	for _, note := range op.notes {
-		if !op.staff.removeNote(note) {
-			op.staff.NoteAt(note).Duration.Set(op.origDur[note])
+	for _, sn := range op.notes {
+		if !sn.Staff.removeNote(sn.Note) {
+			sn.Staff.NoteAt(sn.Note).Duration.Set(op.origDur[sn.Note])
 		}
 	}
 }
@@ -354,6 +370,40 @@ func (op *RepeatNotesOp) undo(score *Score) {
 	}
 }
 
+/* MuteNotes mutes or unmutes the given notes */
+func (score *Score) MuteNotes(muted bool, notes... StaffNote) {
+	if len(notes) == 0 {
+		return
+	}
+	score.update(&MuteNotesOp{muted, notes, nil})
+}
+
+type MuteNotesOp struct {
+	muted bool
+	notes []StaffNote
+	changed []*Note
+}
+
+func (op *MuteNotesOp) apply(score *Score) interface{} {
+	op.changed = op.changed[:0]
+	for _, sn := range op.notes {
+		if sn.Note.Muted != op.muted {
+			sn.Note.Muted = op.muted
+			op.changed = append(op.changed, sn.Note)
+		}
+	}
+	if len(op.changed) == 0 {
+		return nil
+	}
+	return notesChanged(op.notes)
+}
+
+func (op *MuteNotesOp) undo(score *Score) {
+	for _, note := range op.changed {
+		note.Muted = !op.muted
+	}
+}
+
 func (score *Score) RemoveNotes(notes... StaffNote) {
 	if len(notes) == 0 {
 		return
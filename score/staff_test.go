@@ -0,0 +1,46 @@
+package score
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/sqweek/sqribe/plumb"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+func TestMuteNotes(t *testing.T) {
+	score := MkScore(plumb.MkPort())
+	defer score.Close()
+	score.LoadBeats([]FrameN{0, 1000, 2000})
+	staff := MkStaff("test", &TrebleClef, 0)
+	score.AddStaff(staff)
+	a := &Note{60, big.NewRat(1, 1), score.Head, big.NewRat(0, 1), false}
+	b := &Note{64, big.NewRat(1, 1), score.Head, big.NewRat(0, 1), true}
+	score.AddNotes(staff, a, b)
+
+	score.MuteNotes(true, StaffNote{staff, a}, StaffNote{staff, b})
+	if !a.Muted || !b.Muted {
+		t.Fatalf("muted %t %t, expected both", a.Muted, b.Muted)
+	}
+	/* undo only unmutes the note which wasn't muted already */
+	score.Undo()
+	if a.Muted || !b.Muted {
+		t.Fatalf("after undo muted %t %t, expected false true", a.Muted, b.Muted)
+	}
+	score.Redo()
+	if !a.Muted || !b.Muted {
+		t.Fatalf("after redo muted %t %t, expected both", a.Muted, b.Muted)
+	}
+
+	/* muting notes which are all muted already changes nothing, so there's
+	 * nothing to undo */
+	score.MuteNotes(true, StaffNote{staff, a})
+	score.Undo()
+	if a.Muted {
+		t.Error("undo skipped over the mute")
+	}
+	if dup := b.Dup(); !dup.Muted {
+		t.Error("Dup lost the mute")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"github.com/sqweek/sqribe/audio"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	grainMs = 40 // length of each scrub grain
+	scrubMaxSpeed = 4.0 // fastest the scrub position moves, relative to normal playback
+)
+
+/* scrubber plays short overlapping grains of the recording around a target
+ * frame which follows the mouse. The read position chases the target, so
+ * the audio moves at the speed of the drag and falls silent once the mouse
+ * stops. Each grain plays at normal speed, so pitch is kept. */
+type scrubber struct {
+	mu sync.Mutex
+	target FrameN
+	done bool
+
+	wav *wave.Waveform
+	pos float64 // read position of the next grain
+	n, hop int // grain length and spacing, in frames
+	window []float32
+	acc []float32 // overlap-add accumulator, n frames of output
+	avail int // frames of acc ready to output
+	buf []float32
+}
+
+func newScrubber(wav *wave.Waveform, frame FrameN) *scrubber {
+	n := (wav.Rate() * grainMs / 1000) &^ 1
+	sc := &scrubber{
+		target: frame,
+		wav: wav,
+		pos: float64(frame),
+		n: n,
+		hop: n / 2,
+		window: make([]float32, n),
+		acc: make([]float32, n * audio.Channels),
+	}
+	for i := range sc.window {
+		sc.window[i] = float32(0.5 - 0.5 * math.Cos(2 * math.Pi * float64(i) / float64(n)))
+	}
+	return sc
+}
+
+func (sc *scrubber) Seek(frame FrameN) {
+	sc.mu.Lock()
+	sc.target = frame
+	sc.mu.Unlock()
+}
+
+func (sc *scrubber) Finish() {
+	sc.mu.Lock()
+	sc.done = true
+	sc.mu.Unlock()
+}
+
+/* fill renders a block of output, as a Transport.PlayFunc callback */
+func (sc *scrubber) fill(_ FrameN, block []float32) bool {
+	sc.mu.Lock()
+	target, done := sc.target, sc.done
+	sc.mu.Unlock()
+	if done {
+		return false
+	}
+	nc := audio.Channels
+	for len(block) > 0 {
+		if sc.avail == 0 {
+			sc.grain(target)
+		}
+		k := sc.avail
+		if k > len(block) / nc {
+			k = len(block) / nc
+		}
+		copy(block, sc.acc[:k * nc])
+		block = block[k * nc:]
+		copy(sc.acc, sc.acc[k * nc:])
+		for i := len(sc.acc) - k * nc; i < len(sc.acc); i++ {
+			sc.acc[i] = 0
+		}
+		sc.avail -= k
+	}
+	return true
+}
+
+/* grain moves the read position towards target, as fast as scrubMaxSpeed
+ * allows, and overlap-adds the grain found there, readying another hop of
+ * output */
+func (sc *scrubber) grain(target FrameN) {
+	sc.avail = sc.hop
+	Δ := float64(target) - sc.pos
+	max := scrubMaxSpeed * float64(sc.hop)
+	Δ = math.Max(-max, math.Min(max, Δ))
+	if math.Abs(Δ) < 1 {
+		/* mouse at rest, leave the grains to die away */
+		return
+	}
+	sc.pos += Δ
+	f0 := FrameN(sc.pos) - FrameN(sc.n / 2)
+	if f0 < 0 || f0 + FrameN(sc.n) > sc.wav.ToFrame(sc.wav.NSamples) {
+		return
+	}
+	sc.buf = wave.Float(sc.wav.Frames(f0, f0 + FrameN(sc.n) - 1), sc.buf)
+	nc, wc := audio.Channels, sc.wav.Channels
+	for i := 0; i < sc.n; i++ {
+		w := sc.window[i]
+		for c := 0; c < nc; c++ {
+			sc.acc[i * nc + c] += w * sc.buf[i * wc + c % wc]
+		}
+	}
+}
+
+/* scrubDrag plays the recording under the mouse as it is dragged, to help
+ * find exactly where a note starts. Only works while playback is stopped. */
+func (ww *WaveWidget) scrubDrag(mouse image.Point) DragFn {
+	wav := G.wav
+	if wav == nil || G.transport.State() != STOPPED {
+		return nil
+	}
+	frame := ww.FrameAtPixel(mouse.X)
+	sc := newScrubber(wav, frame)
+	ww.SetCursorByFrame(frame, false)
+	if G.transport.PlayFunc(wav.Rate(), sc.fill) == nil {
+		return nil
+	}
+	return func(pos image.Point, finished bool, moved bool)bool {
+		if finished {
+			sc.Finish()
+		} else if moved {
+			frame := ww.FrameAtPixel(pos.X)
+			sc.Seek(frame)
+			ww.SetCursorByFrame(frame, false)
+		}
+		return true
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/sqweek/dialog"
@@ -20,6 +21,7 @@ import (
 	"github.com/sqweek/sqribe/plumb"
 	"github.com/sqweek/sqribe/score"
 	"github.com/sqweek/sqribe/wave"
+	_ "github.com/sqweek/sqribe/wave/ffau"
 )
 
 type PendingLoad struct {
@@ -34,11 +36,14 @@ var G struct {
 	files FileContext
 	score *score.Score
 	wav *wave.Waveform
+	transport *Transport
 
 	/* plumbing */
 	plumb struct {
 		selection *plumb.Port
 		score *plumb.Port
+		decode *plumb.Port
+		transport *plumb.Port
 	}
 
 	/* ui stuff */
@@ -47,17 +52,20 @@ var G struct {
 	instMenu MenuWidget
 	noteMenu MenuWidget
 	overlay *OverlayWidget
+	inspect SpectrumInspector
+	tuning *TuningEstimate
 	font struct {
 		luxi *Font
 	}
 	kb struct {
 		shift bool
+		ctrl bool // held while dragging to scrub
 	}
 }
 
 var ZeroTime time.Time
 
-var AudioExtensions = []string{"mp3", "ogg", "m4a", "wma", "mov", "mp4", "flv", "wmv"}
+var AudioExtensions = append([]string{"mp3", "ogg", "m4a", "wma", "mov", "mp4", "flv", "wmv"}, wave.Extensions()...)
 
 func open(filename string) error {
 	ld, err := Load(filename)
@@ -87,8 +95,8 @@ func Load(filename string) (ld PendingLoad, err error) {
 	if err = os.MkdirAll(App.Cache, 0777); err != nil {
 		return
 	}
-	ld.wavDone = make(chan error)
-	ld.wav, err = wave.NewWaveform(ld.files.Audio, filepath.Join(App.Cache, *cachefile), ld.wavDone)
+	ld.wavDone = make(chan error, 1)
+	ld.wav, err = openWaveform(ld.files.Audio, G.plumb.decode, ld.wavDone)
 	if err != nil {
 		return
 	}
@@ -98,9 +106,10 @@ func Load(filename string) (ld PendingLoad, err error) {
 // Finishes the load; pivots memory model to new pending context
 func (ld *PendingLoad) Pivot() {
 	// point of no return; nothing errors after this and we transition to the new file
+	Synth.SetRate(ld.wav.Rate()) // notes are mixed with the audio at its native rate
+	G.wav = ld.wav
 	ld.s.Restore()
 	G.files = ld.files
-	G.wav = ld.wav
 	old := G.ww.SetWaveform(ld.wav)
 	if old != nil {
 		go old.Close()
@@ -108,6 +117,9 @@ func (ld *PendingLoad) Pivot() {
 
 	go func() {
 		erri := <-ld.wavDone // wait for audio to finish loading
+		if erri == context.Canceled {
+			return // superseded by another file, or shutting down
+		}
 		switch err := erri.(type) {
 		case wave.IOError:
 			alert(err.Error())
@@ -158,7 +170,8 @@ func mustMkFont(filename string, size int) *Font {
 
 var initialTime = flag.Duration("time", 0, "position initial view at this time (eg 1m32s)")
 var profile = flag.String("prof", "", "write cpu profile to file")
-var cachefile = flag.String("cache", "", "cache file name")
+var audioSink = flag.String("sink", "", "play to 'null' (discard) or a WAV file rather than the sound card")
+var cachefile = flag.String("cache", "", "internal: set by the parent process when starting the GUI")
 
 func alert(format string, args... interface{}) {
 	msg := fmt.Sprintf(format, args...)
@@ -178,8 +191,22 @@ func main() {
 	if err := fsinit("net.sqweek.sqribe", "sqribe"); err != nil {
 		fatal(err)
 	}
+	if *listDevices {
+		if err := audio.ListDevices(os.Stdout); err != nil {
+			fatal(err)
+		}
+		return
+	}
 	if *cachefile == "" {
 		main_parent()
+	} else if *bounceFile != "" {
+		confinit()
+		coreinit("null", audio.Device{}) // the bounce doesn't need a sound card
+		err := main_bounce(flag.Arg(0))
+		audio.Shutdown()
+		if err != nil {
+			fatal(err)
+		}
 	} else {
 		wderun(main_child)
 		//XXX should avoid closing GUI if save fails
@@ -258,7 +285,6 @@ func main_parent() {
 	if state != nil && !state.Success() {
 		status = 1
 	}
-	os.Remove(filepath.Join(App.Cache, cachename))
 	if status == 0 && logfile != nil {
 		logfile.Close()
 		os.Remove(logpath)
@@ -266,34 +292,20 @@ func main_parent() {
 	os.Exit(status)
 }
 
-func main_child() {
-	var err error
-	log.Println("sqribe version unknown")
-	confinit()
-
-	if *profile != "" {
-		f, err := os.Create(*profile)
-		if err != nil {
-			fatal(err)
-		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
-	}
-
-	err = audio.Open()
+/* sets up audio, the score and synth; everything short of the UI */
+func coreinit(sink string, dev audio.Device) {
+	err := audio.Open(sink, dev)
 	if err != nil {
 		fatal(err)
 	}
 
 	G.plumb.selection = plumb.MkPort()
 	G.plumb.score = plumb.MkPort()
+	G.plumb.decode = plumb.MkPort()
+	G.plumb.transport = plumb.MkPort()
 
 	G.score = score.MkScore(G.plumb.score)
-
-	G.font.luxi = mustMkFont(MustFind("luxisr.ttf"), 10)
-	G.noteMenu = mkMenu(StringMenuOps{}, "1/16", "1/8", "1/4", "1/2", "1", "2", "3", "4")
-	G.noteMenu.SetDefault("1")
-	G.instMenu = mkMenu(StringMenuOps{toStr: func(item interface{})string {return midi.InstName(item.(int))}}, midi.InstPiano, midi.InstEPiano, midi.InstGuitar, midi.InstEGuitar, midi.InstMuteGuitar, midi.InstViolin, midi.InstHarp, midi.InstVoice)
+	G.transport = NewTransport(G.plumb.transport)
 
 	soundfont := Cfg.FS.SoundFont
 	if soundfont == "" {
@@ -305,6 +317,42 @@ func main_child() {
 	if err != nil {
 		fatal(err)
 	}
+}
+
+func main_child() {
+	log.Println("sqribe version unknown")
+	confinit()
+
+	if *profile != "" {
+		f, err := os.Create(*profile)
+		if err != nil {
+			fatal(err)
+		}
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	remember := audioFlags()
+	sink := Cfg.Audio.Sink
+	if *audioSink != "" {
+		sink = *audioSink
+	}
+	dev := Cfg.AudioDevice()
+	/* the configured device may have been unplugged since it was saved; the
+	 * fallback isn't written back, so it's used again once it returns */
+	dev.Fallback = !deviceFlags()
+	coreinit(sink, dev)
+	if remember {
+		/* only once the device has opened successfully */
+		if err := WriteAudioConfig(); err != nil {
+			log.FS.Println("saving audio settings:", err)
+		}
+	}
+
+	G.font.luxi = mustMkFont(MustFind("luxisr.ttf"), 10)
+	G.noteMenu = mkMenu(StringMenuOps{}, "1/16", "1/8", "1/4", "1/2", "1", "2", "3", "4")
+	G.noteMenu.SetDefault("1")
+	G.instMenu = mkMenu(StringMenuOps{toStr: func(item interface{})string {return midi.InstName(item.(int))}}, midi.InstPiano, midi.InstEPiano, midi.InstGuitar, midi.InstEGuitar, midi.InstMuteGuitar, midi.InstViolin, midi.InstHarp, midi.InstVoice)
 
 	audioFile := flag.Arg(0)
 	var ld PendingLoad
@@ -317,12 +365,14 @@ func main_child() {
 	}
 
 	redraw := make(chan Widget, 10)
+	watchDecode(redraw)
 
 	G.ww = NewWaveWidget(redraw)
 	G.ww.SetScore(G.score)
 
 	G.mixw = NewMixWidget(redraw)
 	G.overlay = NewOverlayWidget(redraw)
+	watchTransport(redraw)
 
 	var view SavedView
 	if len(audioFile) > 0 && lderr == nil {
@@ -335,7 +385,7 @@ func main_child() {
 	// 3. ui painting goroutine
 	// 4. sample prefetch goroutine
 	// 5. synth goroutine
-	// 6. feedback goroutine
+	// 6. transport monitor goroutine
 	// 7. quantizer
 	// 8. io cache fetcher
 	// 9. audio decoder
@@ -352,5 +402,8 @@ func main_child() {
 
 	wg.Wait()
 
+	if G.wav != nil {
+		G.wav.Close() // stops decoding if it's still going
+	}
 	audio.Shutdown()
 }
@@ -6,12 +6,12 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"math"
 	"sync"
 	"time"
 
 	"github.com/skelterjohn/go.wde"
 	_ "github.com/skelterjohn/go.wde/init"
-	"github.com/sqweek/sqribe/audio"
 	"github.com/sqweek/sqribe/log"
 	"github.com/sqweek/sqribe/score"
 )
@@ -98,6 +98,9 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 			if e.Where.In(G.ww.Rect()) {
 				cur = G.ww.MouseMoved(e.Where)
 			}
+			if G.inspect.Active() {
+				G.inspect.Inspect(e.Where)
+			}
 			win.SetCursor(cur)
 		case wde.ScrollEvent:
 			G.ww.ScrollPixels(-e.Delta.X)
@@ -107,11 +110,15 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 			switch e.Key {
 			case wde.KeyLeftShift, wde.KeyRightShift:
 				G.kb.shift = true
+			case wde.KeyLeftControl, wde.KeyRightControl:
+				G.kb.ctrl = true
 			}
 		case wde.KeyUpEvent:
 			switch e.Key {
 			case wde.KeyLeftShift, wde.KeyRightShift:
 				G.kb.shift = false
+			case wde.KeyLeftControl, wde.KeyRightControl:
+				G.kb.ctrl = false
 			}
 		case wde.KeyTypedEvent:
 			log.UI.Println("typed", e.Key, e.Glyph, e.Chord)
@@ -127,7 +134,7 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 				G.score.RemoveNotes(G.ww.SelectedNotes()...)
 				G.ww.SetPasteMode(true)
 			case e.Chord == "control+o":
-				if playState == STOPPED {
+				if G.transport.State() == STOPPED {
 					var err error
 					var f string
 					if err = save(); err == nil {
@@ -150,6 +157,8 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 						alert("MXML export failed: %v", err)
 					}
 				}()
+			case e.Chord == "control+b":
+				bounce()
 			case e.Chord == "control+c":
 				G.ww.Snarf()
 				G.ww.SetPasteMode(true)
@@ -161,8 +170,11 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 				G.mixw.AdjustGain(&Mixer.Midi.Gain, 0.1)
 			case e.Chord == "shift+next":
 				G.mixw.AdjustGain(&Mixer.Midi.Gain, -0.1)
+			case e.Chord == "control+" + wde.KeyReturn:
+				G.ww.AcceptSuggestion()
 			case e.Key == wde.KeyEscape:
 				G.ww.SetPasteMode(false)
+				G.ww.SetSuggestion(nil)
 			case e.Key == wde.KeyLeftArrow:
 				G.ww.Scroll(-0.25)
 			case e.Key == wde.KeyRightArrow:
@@ -179,6 +191,10 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 				Synth.AdjustTuning(-10)
 			case e.Key == wde.KeyF6:
 				Synth.AdjustTuning(10)
+			case e.Key == wde.KeyF7:
+				estimateTuning()
+			case e.Key == wde.KeyF8:
+				calibrate()
 			case e.Key == wde.KeyPrior:
 				G.mixw.AdjustGain(&Mixer.Wave.Gain, 0.1)
 			case e.Key == wde.KeyNext:
@@ -186,8 +202,10 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 			case e.Key == wde.KeySpace:
 				playToggle()
 			case e.Key == wde.KeyReturn:
-				if f, playing := audio.PlayingFrame(); playing {
-					G.score.AddBeat(f)
+				if f, playing := G.transport.Frame(); playing {
+					addTappedBeat(f)
+				} else if f, playing := G.transport.FuncFrame(); playing {
+					calibTap(f) // ignored unless calibrating, eg. while scrubbing
 				}
 			case e.Key == wde.KeyDelete:
 				G.score.RemoveNotes(G.ww.SelectedNotes()...)
@@ -197,10 +215,69 @@ func event(win wde.Window, redraw chan Widget, done chan bool, wg *sync.WaitGrou
 				}
 			case e.Glyph == "t":
 				G.mixw.Toggle(&Mixer.MuteMetronome)
+			case e.Glyph == "T":
+				G.mixw.Metronome((*MetronomeMix).NextSubdiv)
+			case e.Glyph == "b":
+				G.mixw.Metronome((*MetronomeMix).NextBar)
+			case e.Glyph == "w":
+				G.mixw.Metronome((*MetronomeMix).NextVoice)
+			case e.Glyph == "<", e.Glyph == ">":
+				up := e.Glyph == ">"
+				G.mixw.Metronome(func(mm *MetronomeMix) {
+					if up && mm.Pitch < 127 {
+						mm.Pitch++
+					} else if !up && mm.Pitch > 0 {
+						mm.Pitch--
+					}
+				})
+			case e.Glyph == "-", e.Glyph == "=", e.Glyph == "+":
+				δ := 0.1
+				if e.Glyph == "-" {
+					δ = -0.1
+				}
+				G.mixw.Metronome(func(mm *MetronomeMix) {
+					mm.Volume = math.Max(0, math.Min(1, mm.Volume + δ))
+				})
+			case e.Glyph == "p":
+				cyclePractice()
+				G.mixw.refresh <- nil
 			case e.Glyph == "a":
 				G.mixw.Toggle(&Mixer.Wave.Muted)
 			case e.Glyph == "m":
 				G.mixw.Toggle(&Mixer.Midi.Muted)
+			case e.Glyph == "M":
+				toggleMute()
+			case e.Glyph == "o":
+				toggleSolo()
+				redraw <- nil
+			case e.Glyph == "u":
+				audition()
+			case e.Glyph == "g":
+				suggestMelody()
+			case e.Glyph == "G":
+				suggestChords()
+			case e.Glyph == "k":
+				estimateKey()
+			case e.Glyph == "v":
+				toggleVerify()
+			case e.Glyph == "i":
+				G.inspect.Toggle()
+			case e.Glyph == "l":
+				disp := G.ww.Display()
+				disp.Split = !disp.Split
+				G.ww.SetDisplay(disp)
+			case e.Glyph == "r":
+				disp := G.ww.Display()
+				disp.RMS = !disp.RMS
+				G.ww.SetDisplay(disp)
+			case e.Glyph == "d":
+				disp := G.ww.Display()
+				disp.Log = !disp.Log
+				G.ww.SetDisplay(disp)
+			case e.Glyph == "[":
+				shiftBeats(-5 * time.Millisecond)
+			case e.Glyph == "]":
+				shiftBeats(5 * time.Millisecond)
 			case e.Glyph == "q":
 				go G.score.QuantizeBeats()
 			case e.Glyph == "#":
@@ -255,13 +332,24 @@ func quantizeStr() string {
 
 func tuningStr() string {
 	freq := Synth.TuningFreq()
+	if G.tuning.Applies() {
+		return fmt.Sprintf("A=%.4gHz (%.0f%% conf)", freq, 100 * G.tuning.Confidence)
+	}
 	return fmt.Sprintf("A=%.4gHz", freq)
 }
 
+func metronomeStr() string {
+	if Mixer.MuteMetronome {
+		return "click off"
+	}
+	return "click: " + Mixer.Metronome.String()
+}
+
 func drawstatus(dst draw.Image, r image.Rectangle) {
 	bg := color.RGBA{0xcc, 0xcc, 0xcc, 0xff}
 	draw.Draw(dst, r, &image.Uniform{bg}, image.ZP, draw.Src)
-	G.font.luxi.Draw(dst, color.Black, r, fmt.Sprintf("%s  %v  %v", G.ww.Status(), quantizeStr(), tuningStr()))
+	r = drawProgress(dst, r)
+	G.font.luxi.Draw(dst, color.Black, r, fmt.Sprintf("%s  %v  %v  %v  %v  %v", G.ww.Status(), quantizeStr(), tuningStr(), metronomeStr(), practiceStr(), soloStr()))
 }
 
 func drawstuff(w wde.Window, redraw chan Widget, done chan bool) {
@@ -9,7 +9,6 @@ import (
 	"math/big"
 	"strings"
 
-	"github.com/sqweek/sqribe/audio"
 	"github.com/sqweek/sqribe/log"
 	"github.com/sqweek/sqribe/midi"
 	"github.com/sqweek/sqribe/score"
@@ -61,6 +60,7 @@ type stateV3 struct {
 	WaveGain float64 `json:",omitempty"`
 	MidiGain float64 `json:",omitempty"`
 	MetronomeOff bool `json:",omitempty"`
+	Metronome *MetronomeMix `json:",omitempty"`
 	WaveOff bool `json:",omitempty"`
 	MidiOff bool `json:",omitempty"`
 	Pos struct {
@@ -68,6 +68,7 @@ type stateV3 struct {
 		Zoom int
 	}
 	Win SavedView
+	Display WaveDisplay
 }
 
 func savedNotes(staff *score.Staff, beats []FrameN) []string {
@@ -80,7 +81,11 @@ func savedNotes(staff *score.Staff, beats []FrameN) []string {
 		}
 		b := big.NewRat(int64(i), 1)
 		b.Add(b, note.Offset)
-		saved = append(saved, fmt.Sprintf("%s %v %v", midi.PitchName(note.Pitch), note.Duration, b))
+		str := fmt.Sprintf("%s %v %v", midi.PitchName(note.Pitch), note.Duration, b)
+		if note.Muted {
+			str += " muted"
+		}
+		saved = append(saved, str)
 	}
 	return saved
 }
@@ -89,7 +94,7 @@ func loadNotes(sc *score.Score, staff *score.Staff, n int, notefn noteFunc, beat
 	notes := make([]*score.Note, 0, n)
 	beat := sc.Head
 	for i := 0; i < n; i++ {
-		pitch, duration, offset, err := notefn(i)
+		pitch, duration, offset, muted, err := notefn(i)
 		if err != nil {
 			log.FS.Printf("error loading note %d: %v\n", i, err)
 			continue
@@ -100,7 +105,7 @@ func loadNotes(sc *score.Score, staff *score.Staff, n int, notefn noteFunc, beat
 			beat = beat.Next()
 		}
 		offset.Sub(offset, big.NewRat(int64(bi), 1))
-		notes = append(notes, &score.Note{pitch, duration, beat, offset})
+		notes = append(notes, &score.Note{pitch, duration, beat, offset, muted})
 	}
 	return notes
 }
@@ -116,13 +121,21 @@ func savedStaves(score *score.Score, beats []FrameN) []SavedStaff {
 	return saved
 }
 
-type noteFunc func(int)(uint8, *big.Rat, *big.Rat, error)
+type noteFunc func(int)(uint8, *big.Rat, *big.Rat, bool, error)
 
 func noteFnFromStrings(notes []string) noteFunc {
-	return func(i int)(pitch uint8, dur, off *big.Rat, err error) {
+	return func(i int)(pitch uint8, dur, off *big.Rat, muted bool, err error) {
 		f := strings.Split(notes[i], " ")
 		dur = big.NewRat(-1, 1)
 		off = big.NewRat(-1, 1)
+		if len(f) < 3 {
+			err = fmt.Errorf("note '%s': missing fields", notes[i])
+			return
+		}
+		/* optional trailing flags */
+		for _, flag := range f[3:] {
+			muted = muted || flag == "muted"
+		}
 		if pitch, err = midi.ParsePitch(f[0]); err == nil {
 			if _, ok := dur.SetString(f[1]); ok {
 				if _, ok := off.SetString(f[2]); !ok {
@@ -138,9 +151,9 @@ func noteFnFromStrings(notes []string) noteFunc {
 }
 
 func noteFnFromStructs(notes []SavedNote) noteFunc {
-	return func(i int)(pitch uint8, dur, off *big.Rat, err error) {
+	return func(i int)(pitch uint8, dur, off *big.Rat, muted bool, err error) {
 		n := notes[i]
-		return n.Pitch, n.Duration, n.Offset, nil
+		return n.Pitch, n.Duration, n.Offset, false, nil
 	}
 }
 
@@ -192,7 +205,7 @@ func convertFrames(f []FrameN, from, to int) {
 func CaptureState() State {
 	s := stateV(mkHeaders())
 	s.h.Extra["Filename"] = G.files.Audio
-	s.FrameRate = audio.SampleRate
+	s.FrameRate = G.wav.Rate()
 	s.Beats = G.score.BeatFrames()
 	s.Staves = savedStaves(G.score, s.Beats)
 	s.Tuning = Synth.Tuning()
@@ -200,9 +213,12 @@ func CaptureState() State {
 	s.WaveGain = Mixer.Wave.Gain - 1.0
 	s.MidiGain = Mixer.Midi.Gain - 1.0
 	s.MetronomeOff = Mixer.MuteMetronome
+	metro := Mixer.Metronome
+	s.Metronome = &metro
 	s.WaveOff = Mixer.Wave.Muted
 	s.MidiOff = Mixer.Midi.Muted
 	s.Pos.First, s.Pos.Zoom = G.ww.CapturePos()
+	s.Display = G.ww.Display()
 	s.Win.Width, s.Win.Height = RootWin.Size()
 	return s
 }
@@ -220,7 +236,7 @@ func (s *stateV3) View() SavedView {
 }
 
 func (s *stateV3) Restore() {
-	convertFrames(s.Beats, s.FrameRate, audio.SampleRate)
+	convertFrames(s.Beats, s.FrameRate, G.wav.Rate())
 	G.score.LoadBeats(s.Beats)
 	loadStaves(G.score, s.Staves, s.Beats)
 	Synth.SetTuning(s.Tuning)
@@ -228,11 +244,16 @@ func (s *stateV3) Restore() {
 	Mixer.Wave.Gain = s.WaveGain + 1.0
 	Mixer.Midi.Gain = s.MidiGain + 1.0
 	Mixer.MuteMetronome = s.MetronomeOff
+	Mixer.Metronome = DefaultMetronome()
+	if s.Metronome != nil {
+		Mixer.Metronome = *s.Metronome
+	}
 	Mixer.Wave.Muted = s.WaveOff
 	Mixer.Midi.Muted = s.MidiOff
 	if (s.Pos.Zoom != 0) {
 		G.ww.RestorePos(s.Pos.First, s.Pos.Zoom)
 	}
+	G.ww.SetDisplay(s.Display)
 	/* s.Win is restored separately */
 }
 
@@ -288,13 +309,14 @@ func (h *Headers) UnmarshalJSON(buf []byte) (err error) {
 	return nil
 }
 
-var currentVersion = 3
+var currentVersion = 4
 // v2: moved Filename from data to header
 // v3: save notes as strings not structs
+// v4: beats are in frames at the audio file's native rate, rather than the output device's
 
 func stateV(h *Headers) *stateV3 {
 	switch h.Version {
-	case 1, 2, 3:
+	case 1, 2, 3, 4:
 		return &stateV3{h: h}
 	}
 	panic(fmt.Errorf("unknown file version %d", h.Version))
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/sqweek/sqribe/plumb"
+	"github.com/sqweek/sqribe/score"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+func TestSavedNotesMuted(t *testing.T) {
+	sc := score.MkScore(plumb.MkPort())
+	defer sc.Close()
+	beats := []FrameN{0, 1000, 2000}
+	sc.LoadBeats(beats)
+	staff := score.MkStaff("test", &score.TrebleClef, 0)
+	sc.AddStaff(staff)
+	sc.AddNotes(staff,
+		&score.Note{60, big.NewRat(1, 2), sc.Head, big.NewRat(0, 1), true},
+		&score.Note{62, big.NewRat(1, 1), sc.Head.Next(), big.NewRat(1, 2), false})
+
+	saved := savedNotes(staff, beats)
+	loaded := loadNotes(sc, staff, len(saved), noteFnFromStrings(saved), beats)
+	if len(loaded) != 2 {
+		t.Fatalf("loaded %d notes from %v", len(loaded), saved)
+	}
+	for i, note := range staff.Notes() {
+		got := loaded[i]
+		if got.Pitch != note.Pitch || got.Beat != note.Beat || got.Offset.Cmp(note.Offset) != 0 || got.Duration.Cmp(note.Duration) != 0 || got.Muted != note.Muted {
+			t.Errorf("note %d saved as %q loaded as %+v, expected %+v", i, saved[i], got, note)
+		}
+	}
+
+	/* notes saved before muting existed have no flag */
+	if _, _, _, muted, err := noteFnFromStrings([]string{"C4 1/4 3/2"})(0); muted || err != nil {
+		t.Errorf("old note loaded muted=%t err=%v", muted, err)
+	}
+}
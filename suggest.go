@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/sqweek/sqribe/dsp"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/score"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+/* A Suggestion holds notes proposed by analysing the recording. They are
+ * displayed as ghost notes on their staves until the user accepts them. */
+type Suggestion struct {
+	Notes []score.StaffNote
+	Confidence []float64 // per note, on [0,1]
+}
+
+func (s *Suggestion) add(staff *score.Staff, note *score.Note, confidence float64) {
+	for _, sn := range s.Notes {
+		if sn.Staff == staff && sn.Note.Cmp(note) == 0 {
+			return
+		}
+	}
+	s.Notes = append(s.Notes, score.StaffNote{staff, note})
+	s.Confidence = append(s.Confidence, confidence)
+}
+
+/* suggestStaff picks the staff under the mouse, or the first staff if the mouse isn't over one */
+func suggestStaff(ww *WaveWidget) *score.Staff {
+	if staff, _ := ww.staffContaining(ww.mouse.pos); staff != nil {
+		return staff
+	}
+	if staves := ww.score.Staves(); len(staves) > 0 {
+		return staves[0]
+	}
+	return nil
+}
+
+/* snaps a duration (in beats) to the nearest note value */
+func snapDuration(dur *big.Rat) *big.Rat {
+	return ticks2dur(dur2ticks(dur, 96), 96)
+}
+
+/* quantizedNote converts a frame span and pitch into a note snapped to the beat grid */
+func quantizedNote(sc *score.Score, pitch uint8, start, end FrameN) *score.Note {
+	b0, ok := sc.ToBeat(start)
+	if !ok {
+		return nil
+	}
+	beat, offset := sc.Quantize(b0)
+	dur := big.NewRat(1, 4)
+	if bN, ok := sc.ToBeat(end); ok {
+		endBeat, endOffset := sc.Quantize(bN)
+		if d := Δb(endBeat, endOffset, beat, offset); d.Sign() > 0 {
+			dur = d
+		}
+	}
+	return &score.Note{pitch, snapDuration(dur), beat, offset, false}
+}
+
+func freqToPitch(freq float64) (int, float64) {
+	cents := FreqToCents(freq) - Synth.Tuning()
+	pitch := round(cents / 100)
+	return int(pitch), cents - pitch * 100
+}
+
+const (
+	melodyMinFreq = 50.0
+	melodyMaxFreq = 2000.0
+	melodyThreshold = 0.15
+	melodyMinNote = 0.06 // shortest note detected, in seconds
+)
+
+type pitchSegment struct {
+	pitch int
+	first, last int // indices into the pitch track
+	clarity float64 // sum of (1 - aperiodicity) over the segment
+}
+
+/* median filter over midi pitches to remove single-frame octave jumps/dropouts */
+func smoothPitches(pitches []int, radius int) []int {
+	out := make([]int, len(pitches))
+	win := make([]int, 0, 2*radius + 1)
+	for i := range pitches {
+		win = win[:0]
+		for j := i - radius; j <= i + radius; j++ {
+			if j >= 0 && j < len(pitches) {
+				win = append(win, pitches[j])
+			}
+		}
+		sort.Ints(win)
+		out[i] = win[len(win)/2]
+	}
+	return out
+}
+
+/* SuggestMelody runs a monophonic pitch tracker over a range of the recording
+ * and returns the detected notes, snapped to the beat grid of the score. */
+func SuggestMelody(wav *wave.Waveform, sc *score.Score, staff *score.Staff, rng TimeRange) (*Suggestion, error) {
+	if !sc.HasBeats() {
+		return nil, fmt.Errorf("pitch detection requires beats to be placed")
+	}
+	f0, fN := wav.ClipFrame(rng.MinFrame()), wav.ClipFrame(rng.MaxFrame())
+	if fN <= f0 {
+		return nil, fmt.Errorf("pitch detection requires a time selection")
+	}
+	rate := wav.Rate()
+	window := 2 * int(float64(rate) / melodyMinFreq)
+	hop := rate / 100
+	signal := dsp.Mono(wav.Frames(f0, fN), wav.Channels)
+	track := dsp.PitchTrack(signal, rate, window, hop, melodyMinFreq, melodyMaxFreq, melodyThreshold)
+	log.WAV.Printf("pitch track: %d frames over %v", len(track), wav.TimeAtFrame(fN - f0))
+
+	maxRMS := 0.0
+	for _, pf := range track {
+		maxRMS = math.Max(maxRMS, pf.RMS)
+	}
+	pitches := make([]int, len(track))
+	for i, pf := range track {
+		pitches[i] = -1
+		if pf.Freq > 0 && pf.RMS > 0.05 * maxRMS {
+			if p, _ := freqToPitch(pf.Freq); p >= 0 && p < 128 {
+				pitches[i] = p
+			}
+		}
+	}
+	pitches = smoothPitches(pitches, 2)
+
+	segs := make([]pitchSegment, 0, 32)
+	for i, p := range pitches {
+		clarity := 1 - track[i].Aperiodicity
+		if n := len(segs); n > 0 && segs[n-1].pitch == p && segs[n-1].last == i - 1 {
+			segs[n-1].last = i
+			segs[n-1].clarity += clarity
+		} else if p != -1 {
+			segs = append(segs, pitchSegment{p, i, i, clarity})
+		}
+	}
+
+	sugg := &Suggestion{}
+	minFrames := int(melodyMinNote * float64(rate) / float64(hop))
+	centre := FrameN(window / 2)
+	for _, seg := range segs {
+		n := seg.last - seg.first + 1
+		if n < minFrames {
+			continue
+		}
+		start := f0 + FrameN(track[seg.first].Offset) + centre
+		end := f0 + FrameN(track[seg.last].Offset + hop) + centre
+		if note := quantizedNote(sc, uint8(seg.pitch), start, end); note != nil {
+			sugg.add(staff, note, seg.clarity / float64(n))
+		}
+	}
+	return sugg, nil
+}
+
+/* suggestMelody runs melody detection in the background and displays the result */
+func suggestMelody() {
+	wav, sc := G.wav, G.score
+	if wav == nil {
+		return
+	}
+	staff := suggestStaff(G.ww)
+	if staff == nil {
+		alert("pitch detection: add a staff first")
+		return
+	}
+	rng := G.ww.SelectedTimeRange()
+	go func() {
+		sugg, err := SuggestMelody(wav, sc, staff, rng)
+		if err != nil {
+			alert("%v", err)
+			return
+		}
+		log.UI.Printf("pitch detection suggested %d notes", len(sugg.Notes))
+		G.ww.SetSuggestion(sugg)
+	}()
+}
@@ -11,33 +11,92 @@ type Synthesizer struct {
 	fluid fluidsynth.Synth
 	chans map[uint8]uint8 // midi instrument -> channel allocations
 	schedule chan ScheduledEvent
+	quit chan struct{}
 	tuning float64
 	freq float64
+	sfont string
+	rate int
 }
 
 var Synth *Synthesizer
 
 func SynthInit(srate int, sfont string) (*Synthesizer, error) {
-	settings := fluidsynth.NewSettings()
-	settings.SetInt("audio.period-size", srate)
-	settings.SetString("audio.sample-format", "16bits")
-	settings.SetNum("synth.gain", 0.6)
-	settings.SetNum("synth.sample-rate", float64(srate))
 	synth := &Synthesizer{
-		fluid: fluidsynth.NewSynth(settings),
+		fluid: newFluid(srate, sfont),
 		chans: make(map[uint8]uint8),
 		schedule: make(chan ScheduledEvent),
+		quit: make(chan struct{}),
+		sfont: sfont,
+		rate: srate,
 	}
-	/* TODO load sound font in background */
-	synth.fluid.SFLoad(sfont, true)
 	go synth.scheduler()
 	return synth, nil
 }
 
+/* Delete stops the synth and frees its soundfont. It mustn't be used afterwards. */
+func (s *Synthesizer) Delete() {
+	close(s.quit)
+	s.fluid.Delete()
+}
+
+func newFluid(srate int, sfont string) fluidsynth.Synth {
+	settings := fluidsynth.NewSettings()
+	settings.SetInt("audio.period-size", srate)
+	settings.SetString("audio.sample-format", "16bits")
+	settings.SetNum("synth.gain", 0.6)
+	settings.SetNum("synth.sample-rate", float64(srate))
+	fluid := fluidsynth.NewSynth(settings)
+	/* TODO load sound font in background */
+	fluid.SFLoad(sfont, true)
+	return fluid
+}
+
+/* SetRate switches the synth to render at srate, so that it can be mixed with
+ * audio at that rate. Must not be called during playback. */
+func (s *Synthesizer) SetRate(srate int) {
+	if srate == s.rate {
+		return
+	}
+	old := s.fluid
+	s.fluid = newFluid(srate, s.sfont)
+	s.rate = srate
+	s.chans = make(map[uint8]uint8)
+	if s.tuning != 0 {
+		s.SetTuning(s.tuning)
+	}
+	old.Delete()
+}
+
 func (s *Synthesizer) WriteFrames(buf []int16) {
 	s.fluid.WriteS16(buf, buf[1:], 2, 2)
 }
 
+func (s *Synthesizer) WriteFloat(buf []float32) {
+	s.fluid.WriteFloat(buf, buf[1:], 2, 2)
+}
+
+func (s *Synthesizer) SoundFont() string {
+	return s.sfont
+}
+
+func (s *Synthesizer) Rate() int {
+	return s.rate
+}
+
+/* Render plays a single note for nframes and returns the (stereo) output.
+ * Only useful on a synth which isn't also being used for playback. */
+func (s *Synthesizer) Render(inst, pitch, velocity uint8, nframes int) []int16 {
+	ch := s.Inst(inst)
+	buf := make([]int16, 2*nframes)
+	s.NoteOn(ch, pitch, velocity)
+	s.WriteFrames(buf)
+	s.NoteOff(ch, pitch)
+	/* flush the release so the next render starts from silence */
+	tail := make([]int16, 2*s.rate)
+	s.WriteFrames(tail)
+	return buf
+}
+
 /* returns the channel allocated for a particular instrument */
 func (s *Synthesizer) Inst(inst uint8) uint8 {
 	c, ok := s.chans[inst]
@@ -154,6 +213,11 @@ func (s *Synthesizer) scheduler() {
 			if pending != nil {
 				resched()
 			}
+		case <-s.quit:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
 		}
 	}
 }
@@ -175,3 +239,7 @@ func ShiftedTuning(Δcents float64) (tuning [128]float64) {
 func CentsToFreq(cents float64) float64 {
 	return freqA5 * math.Pow(semitoneRatio, (cents - centsA5) / 100.0)
 }
+
+func FreqToCents(freq float64) float64 {
+	return centsA5 + 1200 * math.Log2(freq / freqA5)
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sqweek/sqribe/audio"
+	"github.com/sqweek/sqribe/dsp"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/plumb"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+type PlayState int
+
+const (
+	STOPPED PlayState = iota
+	PLAYING
+	STOPPING
+)
+
+func (s PlayState) String() string {
+	switch s {
+	case PLAYING:
+		return "playing"
+	case STOPPING:
+		return "stopping"
+	}
+	return "stopped"
+}
+
+/* TransportState is sent on the transport's port after each state change */
+type TransportState struct {
+	State PlayState
+	Frame FrameN
+}
+
+/* TransportPos is sent on the transport's port periodically during playback */
+type TransportPos struct {
+	Frame FrameN
+	Follow bool // whether the view should scroll to keep up
+	MidiPeak, WavePeak float64 // loudest samples since the last TransportPos
+}
+
+/* TransportPass is sent on the transport's port as each pass of a loop
+ * begins to play */
+type TransportPass struct {
+	Pass int // counting from 0
+	Speed float64
+	Range TimeRange
+}
+
+type PracticeMode int
+
+const (
+	PracticeOff PracticeMode = iota
+	PracticeSpeedUp // raise the speed after every few passes
+	PracticeAdvance // move the loop on after every few passes
+)
+
+/* Practice configures the transport for learning a passage */
+type Practice struct {
+	Mode PracticeMode
+	Passes int // passes at each step
+	Speed float64 // speed to start at
+	Step float64 // speed increase at each step, when speeding up
+	Advance func(TimeRange) TimeRange // moves the loop on, when advancing
+}
+
+/* Transport owns playback. All starting, stopping and seeking goes through
+ * its methods, which may be called from any goroutine; the audio itself is
+ * fed by goroutines the transport starts and stops. */
+type Transport struct {
+	port *plumb.Port
+
+	mu sync.Mutex
+	state PlayState
+	rng TimeRange
+	loop bool
+	pos FrameN // where to start, or seek to if playing
+	gen int // bumped by each seek; samples fetched before then are discarded
+	countIn int // clicks before a loop starts
+	spacing FrameN // between count-in clicks
+	preRoll FrameN // recording played before the loop start on each pass
+	practice Practice
+	pass int // passes started since playing or changing practice
+	speed float64 // of the pass about to be fetched
+	heard TransportPass // the pass currently playing
+	free bool // playing from a PlayFunc, whose frames aren't the recording's
+	rescored chan PlayChange // changes to what plays, beyond the score itself
+	solo noteSet // the only notes to play, until stopped; nil for all
+	mpeak, wpeak float64
+}
+
+func NewTransport(port *plumb.Port) *Transport {
+	return &Transport{port: port, speed: 1, rescored: make(chan PlayChange, 1)}
+}
+
+func (t *Transport) State() PlayState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+/* Frame returns the frame of the recording currently audible, if playing it */
+func (t *Transport) Frame() (FrameN, bool) {
+	t.mu.Lock()
+	playing := t.state == PLAYING && !t.free
+	t.mu.Unlock()
+	if !playing {
+		return 0, false
+	}
+	return audio.PlayingFrame()
+}
+
+/* FuncFrame returns the frame currently audible of a PlayFunc's output,
+ * counting from 0, if one is playing */
+func (t *Transport) FuncFrame() (FrameN, bool) {
+	t.mu.Lock()
+	playing := t.state == PLAYING && t.free
+	t.mu.Unlock()
+	if !playing {
+		return 0, false
+	}
+	return audio.PlayingFrame()
+}
+
+/* SetLoop sets the range to play. If loop is set playback wraps around at
+ * the end of the range, otherwise it stops there. While playing the change
+ * takes effect from the next buffer. */
+func (t *Transport) SetLoop(rng TimeRange, loop bool) {
+	t.mu.Lock()
+	t.rng, t.loop = rng, loop
+	t.mu.Unlock()
+}
+
+/* SetSolo limits playback to the given notes, or lifts the limit if nil.
+ * It lasts until playback stops. */
+func (t *Transport) SetSolo(notes noteSet) {
+	t.mu.Lock()
+	t.solo = notes
+	t.mu.Unlock()
+	t.rescore()
+}
+
+/* rescore rebuilds the notes being played */
+func (t *Transport) rescore() {
+	select {
+	case t.rescored <- PlayChange{note: true}:
+	default:
+	}
+}
+
+/* Seek moves the play position. While stopped it sets where the next Play
+ * starts from. */
+func (t *Transport) Seek(frame FrameN) {
+	t.mu.Lock()
+	t.pos = frame
+	t.gen++
+	t.mu.Unlock()
+}
+
+/* SetLeadIn configures what precedes a loop. countIn clicks, spacing frames
+ * apart, lead up to the start of the loop when playing from there, and
+ * preRoll frames before the loop start are played on each pass. */
+func (t *Transport) SetLeadIn(countIn int, spacing, preRoll FrameN) {
+	t.mu.Lock()
+	t.countIn, t.spacing, t.preRoll = countIn, spacing, preRoll
+	t.mu.Unlock()
+}
+
+/* loopStart is where each pass of the loop begins, allowing for pre-roll;
+ * caller holds mu */
+func (t *Transport) loopStart(rng TimeRange, loop bool) FrameN {
+	f0 := rng.MinFrame()
+	if !loop || t.preRoll <= 0 {
+		return f0
+	}
+	if f0 < t.preRoll {
+		return 0
+	}
+	return f0 - t.preRoll
+}
+
+/* SetPractice sets the practice mode. Speed changes take effect at the start
+ * of the next pass. */
+func (t *Transport) SetPractice(p Practice) {
+	t.mu.Lock()
+	t.practice, t.pass = p, 0
+	t.speed = 1
+	if p.Mode != PracticeOff && p.Speed > 0 {
+		t.speed = p.Speed
+	}
+	t.mu.Unlock()
+}
+
+/* Pass describes the pass of the loop being heard */
+func (t *Transport) Pass() TransportPass {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.heard
+}
+
+/* nextPass counts a pass of the loop, and carries out the practice routine */
+func (t *Transport) nextPass() (pass int, speed float64) {
+	t.mu.Lock()
+	t.pass++
+	p, rng := t.practice, t.rng
+	advance := false
+	if p.Mode != PracticeOff && p.Passes > 0 && t.pass % p.Passes == 0 {
+		switch p.Mode {
+		case PracticeSpeedUp:
+			t.speed = math.Min(1, t.speed + p.Step)
+		case PracticeAdvance:
+			advance = p.Advance != nil
+		}
+	}
+	pass, speed = t.pass, t.speed
+	t.mu.Unlock()
+	if advance {
+		next := p.Advance(rng)
+		t.mu.Lock()
+		if t.rng == rng {
+			t.rng = next
+		}
+		t.mu.Unlock()
+	}
+	return pass, speed
+}
+
+func (t *Transport) transition(from, to PlayState) bool {
+	t.mu.Lock()
+	ok := t.state == from
+	if ok {
+		t.state = to
+	}
+	frame := t.pos
+	t.mu.Unlock()
+	if ok {
+		log.AU.Printf("transport %v -> %v", from, to)
+		if to != STOPPED {
+			frame, _ = audio.PlayingFrame()
+		}
+		t.port.C <- TransportState{to, frame}
+	}
+	return ok
+}
+
+/* Stop ends playback. It returns straight away; a STOPPED state follows once
+ * the audio has wound down. */
+func (t *Transport) Stop() {
+	t.transition(PLAYING, STOPPING)
+}
+
+/* Play starts playing wav from the current position, which is moved inside
+ * the range first if need be. It returns false if already playing. */
+func (t *Transport) Play(wav *wave.Waveform) bool {
+	if wav == nil || !t.transition(STOPPED, PLAYING) {
+		return false
+	}
+	t.mu.Lock()
+	if t.rng == nil || t.rng.MinFrame() >= t.rng.MaxFrame() {
+		t.rng, t.loop = wave.Range(wav), false
+	}
+	rng, loop, gen := t.rng, t.loop, t.gen
+	t.free = false
+	t.pass = 0
+	t.heard = TransportPass{0, t.speed, rng}
+	heard := t.heard
+	f0, l0 := rng.MinFrame(), t.loopStart(t.rng, t.loop)
+	if t.pos < l0 || t.pos > rng.MaxFrame() {
+		t.pos = f0
+	}
+	/* from the top of the loop, start with the pre-roll and count-in; the
+	 * recording is silent until the pre-roll begins */
+	audible := t.pos
+	if t.pos == f0 {
+		audible = l0
+	}
+	begin := audible
+	var clicks []FrameN
+	if loop && t.pos == f0 && t.countIn > 0 && t.spacing > 0 {
+		for k := t.countIn; k >= 1; k-- {
+			clicks = append(clicks, f0 - FrameN(k) * t.spacing)
+		}
+		if clicks[0] < begin {
+			begin = audible - (audible - clicks[0] + mixBlock - 1) / mixBlock * mixBlock
+		}
+	}
+	t.mu.Unlock()
+	log.AU.Println("starting playback", rng.MinFrame(), rng.MaxFrame(), " @", begin)
+
+	/* the limiter delays the mix, so begin is heard only once its delay
+	 * line has played out */
+	md := newMixdown(Synth, wav.Rate(), audio.Channels)
+	lag := FrameN(float64(md.Latency()) * heard.Speed)
+	if err := audio.PlayAt(begin - lag, wav.Rate(), heard.Speed); err != nil {
+		log.AU.Println("couldn't start stream:", err)
+		t.finish()
+		return false
+	}
+	t.port.C <- heard
+	quit := make(chan struct{})
+	sampch := make(chan Samples, 25)
+	go t.prefetch(wav, begin, audible, gen, heard.Speed, sampch, quit)
+	go t.mix(md, wav, rng, begin, clicks, sampch, quit)
+	go t.monitor()
+	return true
+}
+
+/* PlayFunc plays audio produced by fill, which is handed blocks of mixBlock
+ * frames at the given rate (starting from frame 0) until it returns false or
+ * the transport is stopped. The returned channel is closed once stopped, or is
+ * nil if playback couldn't start. */
+func (t *Transport) PlayFunc(rate int, fill func(frame FrameN, block []float32) bool) <-chan struct{} {
+	if !t.transition(STOPPED, PLAYING) {
+		return nil
+	}
+	t.mu.Lock()
+	t.free = true
+	t.mu.Unlock()
+	if err := audio.Play(0, rate); err != nil {
+		log.AU.Println("couldn't start stream:", err)
+		t.finish()
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		block := make([]float32, int(mixBlock) * audio.Channels)
+		for f := FrameN(0); t.State() == PLAYING && fill(f, block); f += mixBlock {
+			audio.Append(block)
+		}
+		t.finish()
+		close(done)
+	}()
+	go t.monitor()
+	return done
+}
+
+func (t *Transport) finish() {
+	audio.Stop()
+	t.mu.Lock()
+	t.solo = nil
+	t.mu.Unlock()
+	if !t.transition(STOPPING, STOPPED) {
+		t.transition(PLAYING, STOPPED)
+	}
+}
+
+/* prefetch reads the recording ahead of the mixer, so it never waits on the
+ * disk or decoder. Frames before audible (the count-in) are silent. */
+func (t *Transport) prefetch(wav *wave.Waveform, frame, audible FrameN, gen int, speed float64, sampch chan Samples, quit chan struct{}) {
+	defer close(sampch)
+	bufsiz := FrameN(2048) // must be multiple of 64
+	var prev TimeRange
+	var s Samples
+	s.frame, s.gen, s.speed = frame, gen, speed
+	for {
+		t.mu.Lock()
+		/* re-evaluate the range each iteration in case a bounding beat moves */
+		rng, loop := t.rng, t.loop
+		l0 := t.loopStart(rng, loop)
+		if t.gen != s.gen {
+			s.frame, s.gen, s.seek = t.pos, t.gen, true
+			audible = s.frame
+		}
+		t.mu.Unlock()
+		s.rng, s.f0, s.fN = rng, rng.MinFrame(), rng.MaxFrame()
+		if prev != nil && rng != prev {
+			s.seek = true
+		}
+		prev = rng
+		nf := bufsiz
+		if s.frame < audible {
+			if audible - s.frame < nf {
+				nf = audible - s.frame
+			}
+			s.buf = make([]float32, int(nf) * wav.Channels)
+		} else {
+			if s.frame < l0 || s.frame > s.fN {
+				s.frame, s.seek = l0, true
+			}
+			if s.frame + bufsiz > s.fN {
+				// pad to nearest 64th frame, minimum 20 frames
+				nfPad := 19 + (64 - ((s.fN - s.frame + 1) + 19) % 64)
+				wave := wav.FloatFrames(s.frame, s.fN)
+				frame0 := wav.FloatFrames(l0, l0)
+				s.buf = make([]float32, len(wave) + int(nfPad)*len(frame0))
+				copy(s.buf, wave)
+				copy(s.buf[len(wave):], crossfade(wave[len(wave) - len(frame0):], frame0, nfPad))
+				s.last = !loop
+			} else {
+				s.buf = wav.FloatFrames(s.frame, s.frame + bufsiz - 1)
+			}
+			nf = wav.ToFrame(SampleN(len(s.buf)))
+		}
+		select {
+		case sampch <- s:
+		case <-quit:
+			return
+		}
+		if s.last {
+			return
+		}
+		s.seek, s.wrap = false, false
+		s.frame += nf
+		if s.frame >= s.fN {
+			s.frame, s.wrap = l0, true
+			s.pass, s.speed = t.nextPass()
+		}
+	}
+}
+
+/* mix feeds the recording and synth to the audio device. The recording is
+ * stretched to the practice speed on the way through, and the synth is
+ * rendered to match. */
+func (t *Transport) mix(md *mixdown, wav *wave.Waveform, rng TimeRange, start FrameN, clicks []FrameN, sampch chan Samples, quit chan struct{}) {
+	scorechan := make(chan PlayChange)
+	G.plumb.score.Sub(t, coalesced(scorechan))
+
+	t.mu.Lock()
+	md.solo = t.solo
+	t.mu.Unlock()
+	md.Seek(rng.MinFrame(), rng.MaxFrame(), start)
+	md.CountIn(clicks)
+	st := dsp.NewStretcher(wav.Rate(), wav.Channels)
+	bufsiz := int(wav.ToSample(mixBlock))
+	block := make([]float32, bufsiz)
+	/* positions in the stream of samples passed through the stretcher, which
+	 * are mapped back to frames of the recording via the queued Samples */
+	var fed, pos float64
+	var cur Samples
+	queue := make([]Samples, 0, 32)
+	last := false
+	ended := false // reached the end of the range, rather than being stopped
+feed:
+	for t.State() == PLAYING {
+		for !st.Read(block) {
+			if last {
+				ended = true
+				break feed
+			}
+			in, ok := <-sampch
+			if !ok {
+				ended = true
+				break feed
+			}
+			if len(in.buf) < bufsiz || len(in.buf) % bufsiz != 0 {
+				log.AU.Println("stopping: prefetch samples sent in non-64 frame multiple", len(in.buf))
+				break feed
+			}
+			t.mu.Lock()
+			stale := in.gen != t.gen
+			t.mu.Unlock()
+			if stale {
+				continue // fetched before a seek
+			}
+			if in.seek {
+				st.Reset()
+				queue = queue[:0]
+				fed, pos = 0, 0
+			}
+			st.SetSpeed(in.speed)
+			st.Write(in.buf)
+			in.pos = fed
+			fed += float64(wav.ToFrame(SampleN(len(in.buf))))
+			in.buf = nil
+			queue = append(queue, in)
+			last = in.last
+		}
+		/* move on to the Samples now being heard */
+		for len(queue) > 0 && queue[0].pos <= pos {
+			in := queue[0]
+			queue = queue[1:]
+			var changed PlayChange
+			select {
+			case changed = <-scorechan:
+			case changed = <-t.rescored:
+			default:
+			}
+			if !changed.Empty() {
+				t.mu.Lock()
+				md.solo = t.solo
+				t.mu.Unlock()
+				start := time.Now()
+				md.Rescore(changed, in.f0, in.fN, in.frame)
+				log.AU.Printf("playback change processed in %v (beats:%t notes:%t)", time.Now().Sub(start), changed.beat, changed.note)
+			}
+			if in.seek {
+				md.Stop()
+				t.mu.Lock()
+				md.solo = t.solo
+				t.mu.Unlock()
+				md.Seek(in.f0, in.fN, in.frame)
+			} else if in.wrap {
+				/* we just looped back around */
+				md.Rewind()
+			}
+			if in.seek || in.wrap {
+				pos = in.pos
+				lag := FrameN(float64(md.Latency()) * in.speed)
+				audio.PlayAt(in.frame - lag, wav.Rate(), in.speed)
+			}
+			if in.wrap {
+				heard := TransportPass{in.pass, in.speed, in.rng}
+				t.mu.Lock()
+				t.heard = heard
+				t.mu.Unlock()
+				t.port.C <- heard
+			}
+			cur = in
+		}
+		out := md.MixAt(block, float64(cur.frame) + pos - cur.pos, cur.speed)
+		pos += float64(mixBlock) * cur.speed
+		t.mu.Lock()
+		t.mpeak, t.wpeak = math.Max(t.mpeak, md.mpeak), math.Max(t.wpeak, md.wpeak)
+		t.mu.Unlock()
+		md.mpeak, md.wpeak = 0, 0
+		audio.Append(out)
+	}
+	if ended {
+		audio.Append(md.Flush())
+	}
+	md.Stop()
+	close(quit)
+	G.plumb.score.Unsub(t)
+	t.finish()
+}
+
+/* monitor reports the play position, and stops playback if the audio device
+ * stops asking for samples */
+func (t *Transport) monitor() {
+	for t.State() != STOPPED {
+		f, playing := audio.PlayingFrame()
+		if !playing {
+			if t.State() == PLAYING && f != 0 {
+				/* we think we're playing, but the audio callback hasn't
+				 * run for awhile. just stop. */
+				log.AU.Println("stopping: lost audio callback")
+				t.Stop()
+			}
+			break
+		}
+		t.mu.Lock()
+		pos := TransportPos{f, !t.loop, t.mpeak, t.wpeak}
+		t.mpeak, t.wpeak = 0, 0
+		free := t.free
+		t.mu.Unlock()
+		if !free {
+			t.port.C <- pos
+		}
+		time.Sleep(66 * time.Millisecond)
+	}
+}
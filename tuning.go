@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/sqweek/dialog"
+	"github.com/sqweek/sqribe/dsp"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	tuningWindow = 8192 // frames per analysis window
+	tuningWindows = 200 // most windows analysed across the recording
+	tuningPeaks = 8 // spectral peaks taken from each window
+	tuningMinFreq = 100.0 // below this a bin spans too many cents to be useful
+	tuningMaxFreq = 4000.0
+	tuningFloor = 30.0 // dB below the window's loudest peak
+	tuningSilence = 1e-3 // RMS (of samples on [-1,1]) below which a window is skipped
+)
+
+/* TuningEstimate records the result of analysing a recording's reference pitch */
+type TuningEstimate struct {
+	Cents float64 // offset from A440
+	Confidence float64 // on [0,1]
+	wav *wave.Waveform
+}
+
+func (est TuningEstimate) Freq() float64 {
+	return CentsToFreq(centsA5 + est.Cents)
+}
+
+/* EstimateTuning samples windows evenly across the whole recording and
+ * finds the offset from A440 which best fits their spectral peaks. */
+func EstimateTuning(wav *wave.Waveform) (TuningEstimate, error) {
+	rate := wav.Rate()
+	last := wave.Range(wav).MaxFrame()
+	if last < tuningWindow {
+		return TuningEstimate{}, fmt.Errorf("recording too short to estimate tuning")
+	}
+	n := tuningWindows
+	if max := int(last / tuningWindow); max < n {
+		n = max
+	}
+	step := (last - tuningWindow) / FrameN(n)
+	peaks := make([]dsp.Peak, 0, n * tuningPeaks)
+	for i := 0; i < n; i++ {
+		f0 := FrameN(i) * step
+		x := dsp.Mono(wav.Frames(f0, f0 + tuningWindow - 1), wav.Channels)
+		if dsp.RMS(x) < tuningSilence {
+			continue // silence
+		}
+		spec := dsp.MkSpectrum(x, rate)
+		peaks = append(peaks, spec.Peaks(tuningPeaks, tuningMinFreq, tuningMaxFreq, tuningFloor)...)
+	}
+	if len(peaks) == 0 {
+		return TuningEstimate{}, fmt.Errorf("no pitched content found")
+	}
+	cents, conf := dsp.TuningOffset(peaks)
+	return TuningEstimate{cents, conf, wav}, nil
+}
+
+/* Applies reports whether the synth is currently using this estimate */
+func (est *TuningEstimate) Applies() bool {
+	return est != nil && est.wav == G.wav && math.Abs(est.Cents - Synth.Tuning()) < 0.5
+}
+
+/* estimateTuning analyses the recording in the background then offers to apply the result */
+func estimateTuning() {
+	wav := G.wav
+	if wav == nil {
+		return
+	}
+	go func() {
+		est, err := EstimateTuning(wav)
+		if err != nil {
+			alert("tuning estimate: %v", err)
+			return
+		}
+		log.UI.Printf("tuning estimate: %+.1f cents (A=%.4gHz) confidence %.0f%%", est.Cents, est.Freq(), 100 * est.Confidence)
+		G.tuning = &est
+		G.ww.changed(SCALE, nil)
+		if est.Applies() {
+			return
+		}
+		msg := "The recording appears to be tuned to A=%.4gHz (%+.1f cents, %.0f%% confidence).\nApply this tuning?"
+		if dialog.Message(msg, est.Freq(), est.Cents, 100 * est.Confidence).Title("sqribe - tuning").YesNo() {
+			Synth.SetTuning(est.Cents)
+			G.ww.changed(SCALE, nil)
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sqweek/sqribe/wave"
+)
+
+/* sliceSource feeds a Waveform from memory */
+type sliceSource struct {
+	samps []int16
+	rate, channels int
+}
+
+func (s *sliceSource) Format() (int, int) {
+	return s.rate, s.channels
+}
+
+func (s *sliceSource) Read() ([]int16, error) {
+	if len(s.samps) == 0 {
+		return nil, io.EOF
+	}
+	n := 4096 * s.channels
+	if n > len(s.samps) {
+		n = len(s.samps)
+	}
+	out := s.samps[:n]
+	s.samps = s.samps[n:]
+	return out, nil
+}
+
+func (s *sliceSource) Close() error {
+	return nil
+}
+
+/* testWaveform decodes mono float samples, copied to each channel, into a
+ * Waveform. Call the returned func when done with it. */
+func testWaveform(t *testing.T, x []float64, rate, channels int) (*wave.Waveform, func()) {
+	samps := make([]int16, len(x) * channels)
+	for i, v := range x {
+		for c := 0; c < channels; c++ {
+			samps[i * channels + c] = int16(math.Max(-32768, math.Min(32767, v * 32768)))
+		}
+	}
+	dir, err := ioutil.TempDir("", "sqribe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := make(chan error, 1)
+	wav := wave.NewWaveformFrom(context.Background(), &sliceSource{samps, rate, channels}, "test", filepath.Join(dir, "cache"), nil, reply)
+	if err := <-reply; err != nil {
+		t.Fatal(err)
+	}
+	return wav, func() {
+		wav.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+/* tone returns a note with a few harmonics */
+func tone(rate, frames int, freq, amp float64) []float64 {
+	x := make([]float64, frames)
+	for i := range x {
+		for k := 1; k <= 4; k++ {
+			x[i] += amp / float64(k) * math.Sin(2 * math.Pi * float64(k) * freq * float64(i) / float64(rate))
+		}
+	}
+	return x
+}
+
+func TestEstimateTuning(t *testing.T) {
+	rate := 44100
+	/* a quiet A played 20 cents flat */
+	cents := -20.0
+	wav, done := testWaveform(t, tone(rate, 3 * rate, 440 * math.Pow(2, cents / 1200), 0.05), rate, 1)
+	defer done()
+	est, err := EstimateTuning(wav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(est.Cents - cents) > 3 {
+		t.Errorf("estimated %+.1f cents, expected %+.1f", est.Cents, cents)
+	}
+
+	/* silence has nothing to go on */
+	silence, done2 := testWaveform(t, make([]float64, 3 * rate), rate, 1)
+	defer done2()
+	if _, err := EstimateTuning(silence); err == nil {
+		t.Error("estimated tuning of silence")
+	}
+}
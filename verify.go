@@ -0,0 +1,226 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/sqweek/sqribe/dsp"
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/score"
+	"github.com/sqweek/sqribe/wave"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	verifyWindow = 8192 // most frames analysed per note
+	verifyPartials = 6
+	verifyTolerance = 35.0 // cents either side of a partial considered a match
+	verifyMinSupport = 0.1 // notes whose partials are weaker than this (relative to the loudest peak) are flagged
+	verifyPeaks = 6 // strongest peaks considered when looking for missed notes
+	verifySubdiv = 2 // grid cells per beat scanned for missed notes
+	verifyPeakFloor = 12.0 // dB below the loudest peak, for a peak to count as strong
+	verifyMinFreq = 60.0
+	verifyMaxFreq = 2000.0
+)
+
+/* Verification holds the result of comparing the transcription against the recording */
+type Verification struct {
+	Support map[*score.Note]float64 // on [0,1], per placed note
+	Missed []score.StaffNote // strong peaks not explained by any placed note, on a grid of half beats
+}
+
+func (v *Verification) Flagged(note *score.Note) bool {
+	s, ok := v.Support[note]
+	return ok && s < verifyMinSupport
+}
+
+type spanNote struct {
+	score.StaffNote
+	f0, fN FrameN
+	freq float64
+}
+
+/* partialEnergy looks for a peak near each partial of freq, weighting higher partials less */
+func partialEnergy(spec dsp.Spectrum, freq float64) float64 {
+	ratio := math.Pow(2, verifyTolerance / 1200)
+	sum, norm := 0.0, 0.0
+	for k := 1; k <= verifyPartials; k++ {
+		f := freq * float64(k)
+		if f > float64(spec.Rate) / 2 {
+			break
+		}
+		lo, hi := int(spec.Bin(f / ratio)), int(math.Ceil(spec.Bin(f * ratio)))
+		peak := 0.0
+		for i := lo; i <= hi && i < len(spec.Mag); i++ {
+			peak = math.Max(peak, spec.Mag[i])
+		}
+		sum += peak / float64(k)
+		norm += 1 / float64(k)
+	}
+	if norm == 0 {
+		return 0
+	}
+	return sum / norm
+}
+
+/* explains reports whether freq is close to a partial of the note */
+func (sn *spanNote) explains(freq float64) bool {
+	k := math.Floor(freq / sn.freq + 0.5)
+	if k < 1 || k > 2 * verifyPartials {
+		return false
+	}
+	return math.Abs(1200 * math.Log2(freq / (k * sn.freq))) < verifyTolerance
+}
+
+/* Verify checks each placed note against the spectrum of the recording
+ * over the note's span, then scans every beat for missed notes. */
+func Verify(wav *wave.Waveform, sc *score.Score) *Verification {
+	notes := make([]*spanNote, 0, 64)
+	for _, staff := range sc.Staves() {
+		for _, note := range staff.Notes() {
+			f0, ok0 := sc.ToFrame(sc.Beatf(note))
+			fN, okN := sc.ToFrame(sc.EndBeatf(note))
+			if !ok0 || !okN || fN <= f0 {
+				continue
+			}
+			freq := CentsToFreq(float64(int(note.Pitch) * 100) + Synth.Tuning())
+			notes = append(notes, &spanNote{score.StaffNote{staff, note}, f0, fN, freq})
+		}
+	}
+	v := &Verification{Support: make(map[*score.Note]float64)}
+	for _, sn := range notes {
+		/* skip the attack transient */
+		f0 := sn.f0 + (sn.fN - sn.f0) / 10
+		fN := wav.ClipFrame(sn.fN)
+		if fN - f0 > verifyWindow {
+			fN = f0 + verifyWindow
+		}
+		if fN <= f0 {
+			continue
+		}
+		spec := dsp.MkSpectrum(dsp.Mono(wav.Frames(f0, fN), wav.Channels), wav.Rate())
+		max := spec.Max()
+		if max == 0 {
+			v.Support[sn.Note] = 0
+			continue
+		}
+		v.Support[sn.Note] = math.Min(1, partialEnergy(spec, sn.freq) / max)
+	}
+	v.Missed = missedNotes(wav, sc, notes)
+	return v
+}
+
+/* missedNotes looks for strong peaks which no placed note explains, in each
+ * cell of a grid of verifySubdiv cells per beat. Peaks which are harmonics of
+ * a stronger unexplained peak are folded into it, and a pitch found in
+ * consecutive cells becomes one longer note. */
+func missedNotes(wav *wave.Waveform, sc *score.Score, notes []*spanNote) []score.StaffNote {
+	staves := sc.Staves()
+	if len(staves) == 0 {
+		return nil
+	}
+	staffFor := staffForPitch(sc, staves[0])
+	missed := &Suggestion{}
+	sounding := make(map[int]*polyNote) // pitch -> missed note in the previous cell
+	for b := sc.Head; b != nil && b.Next() != nil; b = b.Next() {
+		for i := 0; i < verifySubdiv; i++ {
+			c0, cN := b.FrameAt(float64(i) / verifySubdiv), b.FrameAt(float64(i + 1) / verifySubdiv)
+			found := make(map[int]float64)
+			for _, peak := range unexplainedPeaks(wav, c0, cN, notes) {
+				pitch, _ := freqToPitch(peak.Freq)
+				found[pitch] = math.Max(found[pitch], peak.Mag)
+			}
+			for pitch, pn := range sounding {
+				if _, ok := found[pitch]; !ok {
+					missed.add(pn.staff, pn.note, pn.conf)
+					delete(sounding, pitch)
+				}
+			}
+			for pitch, conf := range found {
+				if pn := sounding[pitch]; pn != nil {
+					pn.note.Duration.Add(pn.note.Duration, big.NewRat(1, verifySubdiv))
+					pn.conf = math.Max(pn.conf, conf)
+					continue
+				}
+				note := &score.Note{uint8(pitch), big.NewRat(1, verifySubdiv), b, big.NewRat(int64(i), verifySubdiv), false}
+				sounding[pitch] = &polyNote{note, staffFor(uint8(pitch)), conf}
+			}
+		}
+	}
+	for _, pn := range sounding {
+		missed.add(pn.staff, pn.note, pn.conf)
+	}
+	for _, sn := range missed.Notes {
+		sn.Note.Duration = snapDuration(sn.Note.Duration)
+	}
+	sort.Slice(missed.Notes, func(i, j int) bool { return missed.Notes[i].Note.Cmp(missed.Notes[j].Note) < 0 })
+	return missed.Notes
+}
+
+/* unexplainedPeaks returns the strong peaks between frames c0 and cN which
+ * aren't partials of a note sounding there, nor harmonics of a stronger
+ * unexplained peak. Magnitudes are relative to the loudest peak. */
+func unexplainedPeaks(wav *wave.Waveform, c0, cN FrameN, notes []*spanNote) []dsp.Peak {
+	f0 := c0 + (cN - c0) / 10
+	fN := wav.ClipFrame(cN)
+	if fN - f0 > verifyWindow {
+		fN = f0 + verifyWindow
+	}
+	if fN <= f0 {
+		return nil
+	}
+	x := dsp.Mono(wav.Frames(f0, fN), wav.Channels)
+	if dsp.RMS(x) < tuningSilence {
+		return nil
+	}
+	spec := dsp.MkSpectrum(x, wav.Rate())
+	max := spec.Max()
+	mid := (f0 + fN) / 2
+	var kept []dsp.Peak
+	/* peaks come loudest first */
+	for _, peak := range spec.Peaks(verifyPeaks, verifyMinFreq, verifyMaxFreq, verifyPeakFloor) {
+		explained := false
+		for _, other := range notes {
+			if other.f0 <= mid && mid < other.fN && other.explains(peak.Freq) {
+				explained = true
+				break
+			}
+		}
+		for _, k := range kept {
+			h := math.Floor(peak.Freq / k.Freq + 0.5)
+			if h >= 2 && math.Abs(1200 * math.Log2(peak.Freq / (h * k.Freq))) < verifyTolerance {
+				explained = true
+				break
+			}
+		}
+		if !explained {
+			kept = append(kept, dsp.Peak{peak.Freq, peak.Mag / max})
+		}
+	}
+	return kept
+}
+
+/* toggleVerify runs verification in the background, or clears the results if already shown */
+func toggleVerify() {
+	if G.ww.Verification() != nil {
+		G.ww.SetVerification(nil)
+		return
+	}
+	wav, sc := G.wav, G.score
+	if wav == nil {
+		return
+	}
+	go func() {
+		v := Verify(wav, sc)
+		flagged := 0
+		for note := range v.Support {
+			if v.Flagged(note) {
+				flagged++
+			}
+		}
+		log.UI.Printf("verify: %d of %d notes unsupported, %d possible missed notes", flagged, len(v.Support), len(v.Missed))
+		G.ww.SetVerification(v)
+	}()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/sqweek/sqribe/plumb"
+	"github.com/sqweek/sqribe/score"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+func TestVerifyMissed(t *testing.T) {
+	synth := Synth
+	defer func() { Synth = synth }()
+	Synth = &Synthesizer{}
+	rate := 44100
+	/* A4 and E♭4 sound together for four beats, but only the A is placed */
+	x := tone(rate, 2 * rate, CentsToFreq(6900), 0.2)
+	for i, y := range tone(rate, 2 * rate, CentsToFreq(6300), 0.2) {
+		x[i] += y
+	}
+	wav, done := testWaveform(t, x, rate, 1)
+	defer done()
+	sc := score.MkScore(plumb.MkPort())
+	defer sc.Close()
+	sc.LoadBeats([]FrameN{0, 22050, 44100, 66150, 88199})
+	staff := score.MkStaff("test", &score.TrebleClef, 0)
+	sc.AddStaff(staff)
+	placed := &score.Note{69, big.NewRat(4, 1), sc.Head, big.NewRat(0, 1), false}
+	sc.AddNotes(staff, placed)
+
+	v := Verify(wav, sc)
+	if v.Flagged(placed) {
+		t.Errorf("placed note flagged, support %.2f", v.Support[placed])
+	}
+	if len(v.Missed) != 1 {
+		for _, sn := range v.Missed {
+			t.Logf("missed %d at beat %v+%v for %v", sn.Note.Pitch, sn.Note.Beat.Frame(), sn.Note.Offset, sn.Note.Duration)
+		}
+		t.Fatalf("%d missed notes, expected just the E♭", len(v.Missed))
+	}
+	missed := v.Missed[0].Note
+	if missed.Pitch != 63 || missed.Beat != sc.Head || missed.Offset.Sign() != 0 || missed.Duration.Cmp(big.NewRat(4, 1)) != 0 {
+		t.Errorf("missed %d at %d+%v for %v beats, expected 63 at 0+0 for 4", missed.Pitch, missed.Beat.Frame(), missed.Offset, missed.Duration)
+	}
+}
@@ -0,0 +1,77 @@
+package wave
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sqweek/sqribe/log"
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+/* cacheMeta is written alongside a cache file once decoding has finished,
+ * and records what's needed to reopen the cache without decoding again. */
+type cacheMeta struct {
+	NSamples SampleN
+	Channels int
+	Rate int
+	Max []int16
+}
+
+func MetaFile(cachefile string) string {
+	return cachefile + ".meta"
+}
+
+/* CacheFiles lists every file which makes up a cache */
+func CacheFiles(cachefile string) []string {
+	return []string{cachefile, PeakFile(cachefile), MetaFile(cachefile)}
+}
+
+func (wav *Waveform) writeMeta(cachefile string) error {
+	f, err := os.Create(MetaFile(cachefile))
+	if err != nil {
+		return err
+	}
+	meta := cacheMeta{wav.NSamples, wav.Channels, wav.rate, wav.Max}
+	err = json.NewEncoder(f).Encode(&meta)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+/* OpenCached reopens a cache file written by a previous NewWaveform. It
+ * fails if decoding didn't complete or the format doesn't match. A rate of
+ * zero accepts whatever rate the cache was written at. */
+func OpenCached(cachefile string, rate, channels int) (*Waveform, error) {
+	f, err := os.Open(MetaFile(cachefile))
+	if err != nil {
+		return nil, err
+	}
+	var meta cacheMeta
+	err = json.NewDecoder(f).Decode(&meta)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if (rate != 0 && meta.Rate != rate) || meta.Channels != channels || len(meta.Max) != channels {
+		return nil, fmt.Errorf("%s: cached format %dHz/%dch doesn't match %dHz/%dch", cachefile, meta.Rate, meta.Channels, rate, channels)
+	}
+	st, err := os.Stat(cachefile)
+	if err != nil {
+		return nil, err
+	}
+	wave := &Waveform{rate: meta.Rate, Channels: channels, NSamples: meta.NSamples, Max: meta.Max}
+	wave.cache = mkcache(1024*1024, 2, cachefile)
+	if st.Size() != int64(meta.NSamples) * int64(wave.cache.sampsz) {
+		wave.cache.Close()
+		return nil, fmt.Errorf("%s: truncated cache (%d bytes)", cachefile, st.Size())
+	}
+	if wave.peaks, err = ReadPeaks(PeakFile(cachefile)); err != nil {
+		wave.cache.Close()
+		return nil, err
+	}
+	wave.cache.finished(st.Size())
+	log.WAV.Printf("reusing cache %s: %d samples", cachefile, meta.NSamples)
+	return wave, nil
+}
@@ -0,0 +1,66 @@
+/* Package ffau registers an ffmpeg based decoder with the wave package,
+ * covering any format ffmpeg understands. Import it for its side effects. */
+package ffau
+
+import (
+	"github.com/sqweek/ffau"
+
+	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/wave"
+)
+
+func init() {
+	wave.Register("ffmpeg", open)
+}
+
+type source struct {
+	ctx *ffau.Context
+	converted *ffau.Stream
+	reader *ffau.S16Stream
+	rate, channels int
+}
+
+/* ffmpeg does its own resampling, so the requested format is always honoured */
+func open(file string, rate, channels int) (wave.Source, error) {
+	ctx, err := ffau.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ctx.OpenAudioStream()
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	log.WAV.Println("raw audiostream format", raw.Format())
+	if rate == 0 {
+		rate = raw.Format().Rate
+	}
+	desired := ffau.AudioFormat{rate, ffau.PackedS16s, ffau.DefaultLayout(channels)}
+	converted, err := ffau.Resample(raw, desired)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	log.WAV.Println("converted audiostream format", converted.Format())
+	reader, err := ffau.NewPackedS16Stream(converted)
+	if err != nil {
+		converted.Close()
+		ctx.Close()
+		return nil, err
+	}
+	return &source{ctx, converted, reader, rate, channels}, nil
+}
+
+func (src *source) Format() (int, int) {
+	return src.rate, src.channels
+}
+
+func (src *source) Read() ([]int16, error) {
+	return src.reader.Read()
+}
+
+func (src *source) Close() error {
+	src.converted.Close()
+	src.ctx.Close()
+	return nil
+}
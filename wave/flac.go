@@ -0,0 +1,489 @@
+package wave
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+func init() {
+	Register("flac", openFLAC, "flac")
+}
+
+var errFLACSync = errors.New("flac: lost frame sync")
+
+/* flacReader is a decoder for the native FLAC container. It handles every
+ * subframe type but ignores seek tables and doesn't verify the MD5 sum. */
+type flacReader struct {
+	f *os.File
+	br bitReader
+	rate, channels, bps int
+	total, decoded int64 // frames in the stream (0 if unknown), and decoded so far
+}
+
+func openFLAC(file string, rate, channels int) (Source, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	fl := &flacReader{f: f, br: bitReader{r: bufio.NewReader(f)}}
+	if err = fl.readMetadata(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("flac: %v", err)
+	}
+	return fl, nil
+}
+
+func (fl *flacReader) readMetadata() error {
+	magic, err := fl.br.bits(32)
+	if err != nil {
+		return err
+	}
+	if magic != 0x664c6143 { // "fLaC"
+		return errors.New("not a FLAC file")
+	}
+	gotInfo := false
+	for last := false; !last; {
+		hdr, err := fl.br.bits(32)
+		if err != nil {
+			return err
+		}
+		last = hdr >> 31 != 0
+		kind, size := (hdr >> 24) & 0x7f, int(hdr & 0xffffff)
+		if kind == 0 {
+			if size < 34 {
+				return errors.New("short STREAMINFO")
+			}
+			var x uint64
+			fl.br.bits(32) // min/max block size
+			fl.br.bits(48) // min/max frame size
+			x, err = fl.br.bits(20)
+			fl.rate = int(x)
+			x, err = fl.br.bits(3)
+			fl.channels = int(x) + 1
+			x, err = fl.br.bits(5)
+			fl.bps = int(x) + 1
+			x, err = fl.br.bits(36)
+			fl.total = int64(x)
+			size -= 18
+			gotInfo = true
+		}
+		for ; size > 0 && err == nil; size-- {
+			_, err = fl.br.bits(8)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !gotInfo {
+		return errors.New("missing STREAMINFO")
+	}
+	return nil
+}
+
+func (fl *flacReader) Format() (int, int) {
+	return fl.rate, fl.channels
+}
+
+func (fl *flacReader) Progress() float64 {
+	if fl.total == 0 {
+		return -1
+	}
+	return float64(fl.decoded) / float64(fl.total)
+}
+
+func (fl *flacReader) Close() error {
+	return fl.f.Close()
+}
+
+var flacBlockSizes = [16]int{0, 192, 576, 1152, 2304, 4608, -8, -16, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+var flacRates = [12]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+var flacSampleSizes = [8]int{0, 8, 12, -1, 16, 20, 24, 32}
+
+func (fl *flacReader) Read() ([]int16, error) {
+	br := &fl.br
+	br.crc8, br.crc16 = 0, 0
+	sync, err := br.bits(8)
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+	hdr, err := br.bits(24)
+	if err != nil {
+		return nil, err
+	}
+	if sync != 0xff || hdr >> 17 != 0x7c {
+		return nil, errFLACSync
+	}
+	bsCode, rateCode := int(hdr >> 12) & 0xf, int(hdr >> 8) & 0xf
+	chans, sizeCode := int(hdr >> 4) & 0xf, int(hdr >> 1) & 0x7
+	if err = br.skipUTF8(); err != nil {
+		return nil, err
+	}
+	blocksize := flacBlockSizes[bsCode]
+	if blocksize < 0 {
+		x, err := br.bits(uint(-blocksize))
+		if err != nil {
+			return nil, err
+		}
+		blocksize = int(x) + 1
+	}
+	switch rateCode {
+	case 12:
+		br.bits(8)
+	case 13, 14:
+		br.bits(16)
+	case 15:
+		return nil, errors.New("flac: invalid sample rate")
+	}
+	bps := fl.bps
+	if sizeCode != 0 {
+		bps = flacSampleSizes[sizeCode]
+	}
+	if blocksize == 0 || bps < 0 {
+		return nil, errors.New("flac: invalid frame header")
+	}
+	crc := br.crc8
+	if x, err := br.bits(8); err != nil {
+		return nil, err
+	} else if uint8(x) != crc {
+		return nil, errors.New("flac: frame header CRC mismatch")
+	}
+
+	nch := fl.channels
+	if chans < 8 && chans + 1 != nch || chans >= 8 && nch != 2 || chans > 10 {
+		return nil, errors.New("flac: unsupported channel assignment")
+	}
+	decoded := make([][]int64, nch)
+	for c := range decoded {
+		cbps := bps
+		/* the side channel needs an extra bit */
+		if chans == 8 && c == 1 || chans == 9 && c == 0 || chans == 10 && c == 1 {
+			cbps++
+		}
+		if decoded[c], err = fl.subframe(blocksize, cbps); err != nil {
+			return nil, err
+		}
+	}
+	br.align()
+	crc16 := br.crc16
+	if x, err := br.bits(16); err != nil {
+		return nil, err
+	} else if uint16(x) != crc16 {
+		return nil, errors.New("flac: frame CRC mismatch")
+	}
+
+	switch chans {
+	case 8: // left/side
+		for i, side := range decoded[1] {
+			decoded[1][i] = decoded[0][i] - side
+		}
+	case 9: // side/right
+		for i, side := range decoded[0] {
+			decoded[0][i] = decoded[1][i] + side
+		}
+	case 10: // mid/side
+		for i := range decoded[0] {
+			mid, side := decoded[0][i] << 1 | decoded[1][i] & 1, decoded[1][i]
+			decoded[0][i], decoded[1][i] = (mid + side) >> 1, (mid - side) >> 1
+		}
+	}
+	fl.decoded += int64(blocksize)
+	out := make([]int16, blocksize * nch)
+	for c, samps := range decoded {
+		for i, s := range samps {
+			if bps > 16 {
+				s >>= uint(bps - 16)
+			} else {
+				s <<= uint(16 - bps)
+			}
+			out[i*nch + c] = int16(s)
+		}
+	}
+	return out, nil
+}
+
+var flacFixed = [5][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+
+func (fl *flacReader) subframe(n, bps int) ([]int64, error) {
+	br := &fl.br
+	hdr, err := br.bits(8)
+	if err != nil {
+		return nil, err
+	}
+	if hdr & 0x80 != 0 {
+		return nil, errors.New("flac: bad subframe padding")
+	}
+	kind := int(hdr >> 1) & 0x3f
+	wasted := 0
+	if hdr & 1 != 0 {
+		for {
+			wasted++
+			bit, err := br.bits(1)
+			if err != nil {
+				return nil, err
+			}
+			if bit == 1 {
+				break
+			}
+		}
+		bps -= wasted
+	}
+	samps := make([]int64, n)
+	switch {
+	case kind == 0:
+		x, err := br.signed(uint(bps))
+		if err != nil {
+			return nil, err
+		}
+		for i := range samps {
+			samps[i] = x
+		}
+	case kind == 1:
+		for i := range samps {
+			if samps[i], err = br.signed(uint(bps)); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 12:
+		order := kind & 7
+		if err = fl.predicted(samps, bps, flacFixed[order], 0); err != nil {
+			return nil, err
+		}
+	case kind >= 32:
+		order := kind & 31 + 1
+		if order > n {
+			return nil, errors.New("flac: LPC order exceeds block size")
+		}
+		for i := 0; i < order; i++ {
+			if samps[i], err = br.signed(uint(bps)); err != nil {
+				return nil, err
+			}
+		}
+		x, err := br.bits(4)
+		if err != nil {
+			return nil, err
+		}
+		if x == 15 {
+			return nil, errors.New("flac: invalid LPC precision")
+		}
+		precision := uint(x) + 1
+		shift, err := br.signed(5)
+		if err != nil {
+			return nil, err
+		}
+		if shift < 0 {
+			return nil, errors.New("flac: negative LPC shift")
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			if coefs[i], err = br.signed(precision); err != nil {
+				return nil, err
+			}
+		}
+		if err = fl.predicted(samps, -1, coefs, uint(shift)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("flac: reserved subframe type %d", kind)
+	}
+	if wasted > 0 {
+		for i := range samps {
+			samps[i] <<= uint(wasted)
+		}
+	}
+	return samps, nil
+}
+
+/* predicted reads warm-up samples (unless bps is -ve, meaning they've been
+ * read already) and the residual, then runs the predictor. coefs[0] applies
+ * to the previous sample. */
+func (fl *flacReader) predicted(samps []int64, bps int, coefs []int64, shift uint) error {
+	order := len(coefs)
+	var err error
+	if order > len(samps) {
+		return errors.New("flac: predictor order exceeds block size")
+	}
+	if bps >= 0 {
+		for i := 0; i < order; i++ {
+			if samps[i], err = fl.br.signed(uint(bps)); err != nil {
+				return err
+			}
+		}
+	}
+	if err = fl.residual(samps, order); err != nil {
+		return err
+	}
+	for i := order; i < len(samps); i++ {
+		var sum int64
+		for j, c := range coefs {
+			sum += c * samps[i - 1 - j]
+		}
+		samps[i] += sum >> shift
+	}
+	return nil
+}
+
+/* residual reads partitioned rice coded values into samps[order:] */
+func (fl *flacReader) residual(samps []int64, order int) error {
+	br := &fl.br
+	method, err := br.bits(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("flac: reserved residual coding method")
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	x, err := br.bits(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << x
+	if len(samps) % partitions != 0 || len(samps) / partitions < order {
+		return errors.New("flac: bad residual partition order")
+	}
+	i := order
+	for p := 0; p < partitions; p++ {
+		end := (p + 1) * len(samps) / partitions
+		k, err := br.bits(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			nbits, err := br.bits(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if samps[i], err = br.signed(uint(nbits)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			if samps[i], err = br.rice(uint(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* bitReader reads big-endian bit fields, keeping running CRCs of the bytes consumed */
+type bitReader struct {
+	r io.ByteReader
+	x uint64 // unconsumed bits, right aligned
+	n uint // number of bits in x
+	crc8 uint8
+	crc16 uint16
+}
+
+func (br *bitReader) bits(n uint) (uint64, error) {
+	for br.n < n {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			/* EOF is only clean at a byte boundary between frames */
+			if err == io.EOF && !(br.n == 0 && n == 8) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		br.crc8 = crc8(br.crc8, b)
+		br.crc16 = crc16(br.crc16, b)
+		br.x = br.x << 8 | uint64(b)
+		br.n += 8
+	}
+	br.n -= n
+	v := br.x >> br.n
+	br.x &= 1 << br.n - 1
+	return v, nil
+}
+
+func (br *bitReader) signed(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := br.bits(n)
+	if v & (1 << (n - 1)) != 0 {
+		return int64(v) - 1 << n, err
+	}
+	return int64(v), err
+}
+
+func (br *bitReader) rice(k uint) (int64, error) {
+	var q uint64
+	for {
+		bit, err := br.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		q++
+	}
+	low, err := br.bits(k)
+	u := q << k | low
+	return int64(u >> 1) ^ -int64(u & 1), err
+}
+
+func (br *bitReader) align() {
+	br.n -= br.n % 8
+	br.x &= 1 << br.n - 1
+}
+
+/* skipUTF8 skips the UTF-8 style coded frame/sample number */
+func (br *bitReader) skipUTF8() error {
+	b, err := br.bits(8)
+	if err != nil {
+		return err
+	}
+	extra := 0
+	for mask := uint64(0x80); b & mask != 0 && mask > 1; mask >>= 1 {
+		extra++
+	}
+	if extra == 1 || extra > 7 {
+		return errors.New("flac: bad coded frame number")
+	}
+	if extra > 1 {
+		extra--
+	}
+	for ; extra > 0; extra-- {
+		if _, err := br.bits(8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func crc8(crc uint8, b byte) uint8 {
+	crc ^= b
+	for i := 0; i < 8; i++ {
+		if crc & 0x80 != 0 {
+			crc = crc << 1 ^ 0x07
+		} else {
+			crc <<= 1
+		}
+	}
+	return crc
+}
+
+func crc16(crc uint16, b byte) uint16 {
+	crc ^= uint16(b) << 8
+	for i := 0; i < 8; i++ {
+		if crc & 0x8000 != 0 {
+			crc = crc << 1 ^ 0x8005
+		} else {
+			crc <<= 1
+		}
+	}
+	return crc
+}
@@ -0,0 +1,155 @@
+package wave
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/* bitWriter builds FLAC streams for the decoder to chew on */
+type bitWriter struct {
+	buf []byte
+	x uint64
+	n uint
+}
+
+func (bw *bitWriter) bits(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		bw.x = bw.x << 1 | (v >> uint(i)) & 1
+		bw.n++
+		if bw.n == 8 {
+			bw.buf = append(bw.buf, byte(bw.x))
+			bw.x, bw.n = 0, 0
+		}
+	}
+}
+
+func (bw *bitWriter) signed(v int64, n uint) {
+	bw.bits(uint64(v) & (1 << n - 1), n)
+}
+
+func (bw *bitWriter) rice(v int64, k uint) {
+	u := uint64(v << 1 ^ v >> 63)
+	for q := u >> k; q > 0; q-- {
+		bw.bits(0, 1)
+	}
+	bw.bits(1, 1)
+	bw.bits(u, k)
+}
+
+func (bw *bitWriter) align() {
+	for bw.n != 0 {
+		bw.bits(0, 1)
+	}
+}
+
+/* residual writes a single rice partition */
+func (bw *bitWriter) residual(res []int64) {
+	bw.bits(0, 2) // rice, 4-bit params
+	bw.bits(0, 4) // partition order
+	bw.bits(4, 4) // rice param
+	for _, r := range res {
+		bw.rice(r, 4)
+	}
+}
+
+func (bw *bitWriter) frame(number int, chans int, subframes func()) {
+	start := len(bw.buf)
+	bw.bits(0xfff8, 16) // sync, fixed blocksize
+	bw.bits(7, 4) // 16-bit blocksize follows
+	bw.bits(0, 4) // rate from STREAMINFO
+	bw.bits(uint64(chans), 4)
+	bw.bits(4, 3) // 16 bits per sample
+	bw.bits(0, 1)
+	bw.bits(uint64(number), 8) // frame number, single byte utf8
+	bw.bits(flacTestBlock - 1, 16)
+	var c8 uint8
+	for _, b := range bw.buf[start:] {
+		c8 = crc8(c8, b)
+	}
+	bw.bits(uint64(c8), 8)
+	subframes()
+	bw.align()
+	var c16 uint16
+	for _, b := range bw.buf[start:] {
+		c16 = crc16(c16, b)
+	}
+	bw.bits(uint64(c16), 16)
+}
+
+const flacTestBlock = 64
+
+func TestFLAC(t *testing.T) {
+	left := make([]int64, 2*flacTestBlock)
+	right := make([]int64, 2*flacTestBlock)
+	for i := range left {
+		left[i] = int64(i * 37 % 200 - 100)
+		right[i] = int64(i * i % 151 - 75)
+	}
+	for i := flacTestBlock; i < 2*flacTestBlock; i++ {
+		left[i] = -1234 // constant subframe
+	}
+
+	bw := &bitWriter{}
+	bw.bits(0x664c6143, 32)
+	bw.bits(1 << 31 | 34, 32) // last block, STREAMINFO
+	bw.bits(flacTestBlock, 16)
+	bw.bits(flacTestBlock, 16)
+	bw.bits(0, 48)
+	bw.bits(44100, 20)
+	bw.bits(1, 3) // 2 channels
+	bw.bits(15, 5) // 16 bits
+	bw.bits(uint64(len(left)), 36)
+	bw.bits(0, 64)
+	bw.bits(0, 64)
+
+	/* frame 0: left/side stereo, left as 2nd order fixed, side verbatim */
+	l, r := left[:flacTestBlock], right[:flacTestBlock]
+	bw.frame(0, 8, func() {
+		bw.bits(10 << 1, 8)
+		bw.signed(l[0], 16)
+		bw.signed(l[1], 16)
+		res := make([]int64, 0, len(l))
+		for i := 2; i < len(l); i++ {
+			res = append(res, l[i] - (2 * l[i-1] - l[i-2]))
+		}
+		bw.residual(res)
+		bw.bits(1 << 1, 8)
+		for i := range l {
+			bw.signed(l[i] - r[i], 17)
+		}
+	})
+	/* frame 1: independent channels, left constant, right 1st order LPC */
+	l, r = left[flacTestBlock:], right[flacTestBlock:]
+	bw.frame(1, 1, func() {
+		bw.bits(0, 8)
+		bw.signed(l[0], 16)
+		bw.bits(32 << 1, 8)
+		bw.signed(r[0], 16)
+		bw.bits(14, 4) // 15 bit precision
+		bw.signed(13, 5) // shift
+		bw.signed(8192, 15) // coefficient ~1.0
+		res := make([]int64, 0, len(r))
+		for i := 1; i < len(r); i++ {
+			res = append(res, r[i] - (8192 * r[i-1]) >> 13)
+		}
+		bw.residual(res)
+	})
+
+	file := tempFile(t, "test.flac", bw.buf)
+	defer os.RemoveAll(filepath.Dir(file))
+	src, err := OpenSource(file, 44100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	got := readAll(t, src)
+	if len(got) != 2*len(left) {
+		t.Fatalf("got %d samples, expected %d", len(got), 2*len(left))
+	}
+	for i := range left {
+		if int64(got[2*i]) != left[i] || int64(got[2*i+1]) != right[i] {
+			t.Fatalf("frame %d: got %d/%d, expected %d/%d", i, got[2*i], got[2*i+1], left[i], right[i])
+		}
+	}
+}
@@ -16,17 +16,32 @@ type DecodeError error
 
 var failure = &Chunk{} // non-nil sentinel used to avoid deadlock on i/o failure
 
+const (
+	listenerQueue = 16 // chunk notifications buffered per listener before old ones are dropped
+	readAhead = 2 // chunks prefetched beyond the last one requested, in the direction of travel
+)
+
+type fetchReq struct {
+	id uint64
+	prefetch bool // speculative; dropped rather than retried if the block isn't ready
+}
+
 type cache struct {
 	blocksz uint /* block size in bytes */
 	sampsz uint /* number of bytes to store one sample */
-	chunks map[uint64]*Chunk
-	lru ChunkList
 
 	file string /* backing filename */
-	iochan chan uint64 /* list of blocks that need fetching */
+	iochan chan fetchReq /* list of blocks that need fetching */
+	quit chan struct{} /* closed when the cache is shut down */
 
+	/* everything below is protected by mu */
+	mu sync.Mutex
+	chunks map[uint64]*Chunk
+	lru ChunkList
 	iodone *sync.Cond /* triggered whenever a block completes */
 	listeners []chan *Chunk
+	lastGet uint64 /* most recently requested chunk, for read-ahead */
+	dir int /* direction requests are travelling in: -1, 0 or 1 */
 
 	bytesWritten int64 /* -1 if decoding & writing has finished */
 	lastChunkId uint64 /* id of the last valid chunk */
@@ -35,33 +50,42 @@ type cache struct {
 
 func mkcache(blocksz, sampsz uint, file string) *cache {
 	cache := cache{blocksz: blocksz, sampsz: sampsz, file: file}
-	cache.iochan = make(chan uint64, 20)
+	cache.iochan = make(chan fetchReq, 20)
+	cache.quit = make(chan struct{})
 	cache.chunks = make(map[uint64]*Chunk)
 	cache.listeners = make([]chan *Chunk, 0, 10)
-	cache.iodone = sync.NewCond(&sync.Mutex{})
-	cache.lru.max = 100
+	cache.iodone = sync.NewCond(&cache.mu)
+	cache.lru.init(100)
 	go cache.fetcher()
 	return &cache
 }
 
 func (c *cache) Close() {
-	close(c.iochan)
+	close(c.quit)
 }
 
 func (c *cache) MaxSize() uint64 {
 	return uint64(c.blocksz) * uint64(c.lru.max)
 }
 
+/* complete reports whether decoding has finished writing the cache file */
+func (c *cache) complete() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytesWritten == -1
+}
+
 // Writes out the cache file, given a func which successively spits out decoded
 // wave samples, and returns io.EOF when complete. If the returned error is non-nil,
 // it will be of type IOError or DecodeError depending on where it occurred.
 func (c *cache) Write(readfn func() ([]int16, error)) error {
+	var written int64
 	defer func() {
-		c.lastChunkId = uint64(c.bytesWritten / int64(c.blocksz))
-		c.lastChunkSize = uint(c.bytesWritten % int64(c.blocksz))
-		c.bytesWritten = -1
+		c.finished(written)
+		c.mu.Lock()
 		log.WAV.Printf("cache written: last=%d %d\n", c.lastChunkId, c.lastChunkSize)
 		c.broadcast(nil)
+		c.mu.Unlock()
 	}()
 	f, err := os.Create(c.file)
 	if err != nil {
@@ -73,20 +97,32 @@ func (c *cache) Write(readfn func() ([]int16, error)) error {
 		if err != nil && err != io.EOF {
 			return DecodeError(err)
 		}
-		if err == io.EOF {
-			break
-		}
 		if len(buf) > 0 {
-			err = binary.Write(f, binary.LittleEndian, buf)
-			if err != nil {
+			if err := binary.Write(f, binary.LittleEndian, buf); err != nil {
 				return IOError(err)
 			}
-			c.bytesWritten += int64(len(buf)) * int64(c.sampsz)
+			written += int64(len(buf)) * int64(c.sampsz)
+			c.mu.Lock()
+			c.bytesWritten = written
+			c.mu.Unlock()
+		}
+		if err == io.EOF {
+			break
 		}
 	}
 	return nil
 }
 
+/* marks the cache file as complete at the given size */
+func (c *cache) finished(nbytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastChunkId = uint64(nbytes / int64(c.blocksz))
+	c.lastChunkSize = uint(nbytes % int64(c.blocksz))
+	c.bytesWritten = -1
+	c.iodone.Broadcast() // wake anyone waiting on chunks past the end
+}
+
 func (c *cache) Bounds(sample0, sampleN SampleN) (uint64, uint64) {
 	return c.Containing(sample0), c.Containing(sampleN)
 }
@@ -95,14 +131,72 @@ func (c *cache) Containing(sample SampleN) uint64 {
 	return uint64((sample * SampleN(c.sampsz)) / SampleN(c.blocksz))
 }
 
+/* pastEnd must be called with mu held */
+func (c *cache) pastEnd(id uint64) bool {
+	return c.bytesWritten == -1 && id > c.lastChunkId
+}
+
+/* request queues a chunk for the fetcher. returns false if the cache has been closed */
+func (c *cache) request(req fetchReq) bool {
+	if req.prefetch {
+		select {
+		case c.iochan <- req:
+		case <-c.quit:
+			return false
+		default: // fetcher is busy; prefetch is only a hint
+		}
+		return true
+	}
+	select {
+	case c.iochan <- req:
+		return true
+	case <-c.quit:
+		return false
+	}
+}
+
+/* prefetch requests the chunks ahead of id in the direction of travel. called with mu held */
+func (c *cache) prefetch(id uint64) []uint64 {
+	if id == c.lastGet + 1 {
+		c.dir = 1
+	} else if id + 1 == c.lastGet {
+		c.dir = -1
+	} else if id != c.lastGet {
+		c.dir = 0
+	}
+	c.lastGet = id
+	ids := make([]uint64, 0, readAhead)
+	for i := 1; i <= readAhead && c.dir != 0; i++ {
+		next := int64(id) + int64(i * c.dir)
+		if next < 0 || c.pastEnd(uint64(next)) {
+			break
+		}
+		if _, ok := c.chunks[uint64(next)]; !ok {
+			ids = append(ids, uint64(next))
+		}
+	}
+	return ids
+}
+
 func (c *cache) Get(id uint64) *Chunk {
-	if c.bytesWritten == -1 && id > c.lastChunkId {
+	c.mu.Lock()
+	if c.pastEnd(id) {
+		c.mu.Unlock()
 		return nil
 	}
-	c.iochan <- id /* signal to fetcher goroutine that this chunk is active */
 	chunk, ok := c.chunks[id] /* grab chunk if its already in cache */
+	if ok && chunk != failure {
+		c.lru.touch(chunk)
+	}
+	ahead := c.prefetch(id)
+	c.mu.Unlock()
 	if !ok || chunk == failure {
-		return nil
+		/* signal to fetcher goroutine that this chunk is active */
+		c.request(fetchReq{id, false})
+		chunk = nil
+	}
+	for _, next := range ahead {
+		c.request(fetchReq{next, true})
 	}
 	return chunk
 }
@@ -113,71 +207,109 @@ func (c *cache) Wait(id uint64) *Chunk {
 	if chunk != nil {
 		return chunk
 	}
-	c.iodone.L.Lock()
-	defer c.iodone.L.Unlock()
-	ok := false
-	for !ok {
-		c.iodone.Wait()
-		chunk, ok = c.chunks[id]
-		if chunk == failure {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		select {
+		case <-c.quit:
+			return nil
+		default:
+		}
+		if chunk, ok := c.chunks[id]; ok {
+			if chunk == failure {
+				return nil
+			}
+			return chunk
+		}
+		if c.pastEnd(id) {
 			return nil
 		}
+		c.iodone.Wait()
 	}
-	return chunk
 }
 
 func (c *cache) fetcher() {
 	var fd *os.File
-	for id := range c.iochan {
-		if chunk, ok := c.chunks[id]; ok && chunk != failure {
+	defer func() {
+		if fd != nil {
+			fd.Close()
+		}
+		/* release anyone still waiting */
+		c.mu.Lock()
+		c.iodone.Broadcast()
+		c.mu.Unlock()
+	}()
+	for {
+		var req fetchReq
+		select {
+		case req = <-c.iochan:
+		case <-c.quit:
+			return
+		}
+		id := req.id
+		c.mu.Lock()
+		chunk, ok := c.chunks[id]
+		if ok && chunk != failure {
 			/* chunk already in cache, no i/o necessary just bump the lru */
 			c.lru.touch(chunk)
+			c.mu.Unlock()
+			continue
+		}
+		if c.pastEnd(id) {
+			/* this chunk is past EOF; cache nil */
+			c.add(id, nil)
+			c.mu.Unlock()
 			continue
 		}
-		filename, offset := c.pos(id)
+		offset, nbytes := c.pos(id)
+		c.mu.Unlock()
 		if offset == -1 {
+			if req.prefetch {
+				continue
+			}
 			/* block not written yet - back on the queue */
 			log.WAV.Printf("fetcher: requeing block %d\n", id)
-			go func() { time.Sleep(500 * time.Millisecond); c.iochan <- id }()
-			continue
-		}
-		if c.bytesWritten == -1 && id > c.lastChunkId {
-			/* this chunk is past EOF; cache nil */
-			c.add(id, nil)
+			go func() { time.Sleep(500 * time.Millisecond); c.request(req) }()
 			continue
 		}
 		if fd == nil {
 			var err error
-			if fd, err = os.Open(filename); err != nil {
+			if fd, err = os.Open(c.file); err != nil {
 				log.WAV.Printf("fetcher: %v\n", err)
 				continue
 			}
-			defer fd.Close()
 		}
-		chunk, err := c.readchunk(id, fd, offset)
+		chunk, err := c.readchunk(id, fd, offset, nbytes)
+		c.mu.Lock()
 		if err != nil {
 			log.WAV.Printf("fetcher: chunk %d: %v\n", id, err)
 			c.add(id, failure)
-			continue
+		} else {
+			log.WAV.Printf("fetcher: read chunk %d: i0=%d len=%d\n", id, chunk.I0, len(chunk.Data))
+			c.add(id, chunk)
 		}
-		log.WAV.Printf("fetcher: read chunk %d: i0=%d len=%d\n", id, chunk.I0, len(chunk.Data))
-		c.add(id, chunk)
+		c.mu.Unlock()
 	}
 }
 
-func (c *cache) pos(id uint64) (string, int64) {
+/* returns the offset and size of a chunk in the file, or -1 if it hasn't
+ * been written yet. called with mu held. */
+func (c *cache) pos(id uint64) (int64, uint) {
 	offset := int64(id) * int64(c.blocksz)
 	if c.bytesWritten != -1 && offset + int64(c.blocksz) > c.bytesWritten {
-		offset = -1; /* cache still initialising, block not written yet */
+		return -1, 0 /* cache still initialising, block not written yet */
+	}
+	nbytes := c.blocksz
+	if c.bytesWritten == -1 && id == c.lastChunkId {
+		nbytes = c.lastChunkSize
 	}
-	return c.file, offset
+	return offset, nbytes
 }
 
+/* called with mu held */
 func (c *cache) add(id uint64, chunk *Chunk) {
 	c.chunks[id] = chunk
-	c.iodone.L.Lock()
 	c.iodone.Broadcast()
-	c.iodone.L.Unlock()
 	if chunk == nil || chunk == failure {
 		return // don't add nil or failure to the LRU
 	}
@@ -188,21 +320,35 @@ func (c *cache) add(id uint64, chunk *Chunk) {
 	}
 }
 
+/* notifies listeners without blocking; if a listener's queue is full its
+ * oldest notification is discarded. called with mu held. */
 func (c *cache) broadcast(chunk *Chunk) {
 	for _, l := range(c.listeners) {
-		l := l
-		go func() {l <- chunk}()
+		for sent := false; !sent; {
+			select {
+			case l <- chunk:
+				sent = true
+			default:
+				select {
+				case <-l:
+				default:
+				}
+			}
+		}
 	}
 }
 
 func (c *cache) listen() <-chan *Chunk {
-	// TODO synchronize listen/ignore
-	listener := make(chan *Chunk)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	listener := make(chan *Chunk, listenerQueue)
 	c.listeners = append(c.listeners, listener)
 	return listener
 }
 
 func (c *cache) ignore(listener <-chan *Chunk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for i, l := range(c.listeners) {
 		if l == listener {
 			close(c.listeners[i])
@@ -218,15 +364,29 @@ type ChunkNode struct {
 	prev *ChunkNode
 }
 
+/* ChunkList is a doubly linked LRU list, indexed by chunk id for O(1) touch */
 type ChunkList struct {
 	size uint
 	max uint
 	head *ChunkNode
 	tail *ChunkNode
+	nodes map[uint64]*ChunkNode
+}
+
+func (lru *ChunkList) init(max uint) {
+	lru.max = max
+	lru.nodes = make(map[uint64]*ChunkNode)
 }
 
 func (lru *ChunkList) add(chunk *Chunk) *Chunk {
+	if node, ok := lru.nodes[chunk.id]; ok {
+		/* replacing a chunk which is already listed */
+		node.chunk = chunk
+		lru.promote(node)
+		return nil
+	}
 	node := &ChunkNode{chunk: chunk, next: lru.head}
+	lru.nodes[chunk.id] = node
 	if lru.head == nil {
 		lru.tail = node
 	} else {
@@ -237,6 +397,7 @@ func (lru *ChunkList) add(chunk *Chunk) *Chunk {
 		gone := lru.tail
 		gone.prev.next = nil
 		lru.tail = gone.prev
+		delete(lru.nodes, gone.chunk.id)
 		return gone.chunk
 	}
 	lru.size++
@@ -263,24 +424,16 @@ func (lru *ChunkList) touch(chunk *Chunk) {
 	if chunk == nil {
 		return
 	}
-	/* having to walk the list is daft... */
-	for node := lru.head; node != nil; node = node.next {
-		if node.chunk == chunk {
-			lru.promote(node)
-			return
-		}
+	if node, ok := lru.nodes[chunk.id]; ok && node.chunk == chunk {
+		lru.promote(node)
 	}
 }
 
-func (c *cache) readchunk(id uint64, file *os.File, offset int64) (*Chunk, error) {
+func (c *cache) readchunk(id uint64, file *os.File, offset int64, nbytes uint) (*Chunk, error) {
 	_, err := file.Seek(offset, 0)
 	if err != nil {
 		return nil, err
 	}
-	nbytes := c.blocksz
-	if c.bytesWritten == -1 && id == c.lastChunkId {
-		nbytes = c.lastChunkSize
-	}
 	chunk := Chunk{I0: SampleN(offset)/SampleN(c.sampsz), Data: make([]int16, nbytes/c.sampsz), id: id}
 	err = binary.Read(file, binary.LittleEndian, chunk.Data)
 	return &chunk, err
@@ -0,0 +1,46 @@
+package wave
+
+import (
+	"testing"
+)
+
+func TestChunkList(t *testing.T) {
+	var lru ChunkList
+	lru.init(3)
+	chunks := make([]*Chunk, 5)
+	for i := range chunks {
+		chunks[i] = &Chunk{id: uint64(i)}
+	}
+	for _, c := range chunks[:3] {
+		if gone := lru.add(c); gone != nil {
+			t.Fatalf("evicted %d before the list was full", gone.id)
+		}
+	}
+	lru.touch(chunks[0]) // now 1 is least recently used
+	if gone := lru.add(chunks[3]); gone != chunks[1] {
+		t.Errorf("expected chunk 1 to be evicted, got %v", gone)
+	}
+	if gone := lru.add(chunks[4]); gone != chunks[2] {
+		t.Errorf("expected chunk 2 to be evicted, got %v", gone)
+	}
+	if len(lru.nodes) != 3 {
+		t.Errorf("index holds %d nodes, expected 3", len(lru.nodes))
+	}
+}
+
+func TestBroadcastBounded(t *testing.T) {
+	c := &cache{}
+	l := make(chan *Chunk, 2)
+	c.listeners = append(c.listeners, l)
+	chunks := []*Chunk{{id: 0}, {id: 1}, {id: 2}}
+	/* nobody is reading, but broadcast must not block */
+	for _, chunk := range chunks {
+		c.broadcast(chunk)
+	}
+	if got := <-l; got != chunks[1] {
+		t.Errorf("expected oldest notification to be dropped, got chunk %d", got.id)
+	}
+	if got := <-l; got != chunks[2] {
+		t.Errorf("expected latest notification, got chunk %d", got.id)
+	}
+}
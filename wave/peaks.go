@@ -0,0 +1,235 @@
+package wave
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+const (
+	peakBase = 8 // log2 of frames summarised by each level 0 bucket
+	peakMagic = "SQPK"
+	peakVersion = 1
+)
+
+/* Summary describes a bucket of frames on a single channel */
+type Summary struct {
+	Min, Max int16
+	MeanSq float32 // mean squared sample value
+}
+
+func (s Summary) RMS() float64 {
+	return math.Sqrt(float64(s.MeanSq))
+}
+
+func (s *Summary) merge(t Summary) {
+	if t.Min < s.Min {
+		s.Min = t.Min
+	}
+	if t.Max > s.Max {
+		s.Max = t.Max
+	}
+	/* mean of means is close enough; only the final bucket of a level can be short */
+	s.MeanSq = (s.MeanSq + t.MeanSq) / 2
+}
+
+/* Peaks is a pyramid of min/max/rms summaries. Level l holds one Summary per
+ * channel for every 2^(peakBase+l) frames, so any span of frames can be
+ * summarised by visiting a handful of buckets. */
+type Peaks struct {
+	mu sync.RWMutex
+	channels int
+	levels [][]Summary // levels[l][i*channels + c]
+	nframes FrameN // frames covered by complete level 0 buckets
+
+	/* partial level 0 bucket, only touched by the decoder */
+	acc []Summary
+	accSq []float64
+	accN int
+	nsamples SampleN
+}
+
+func newPeaks(channels int) *Peaks {
+	pk := &Peaks{channels: channels}
+	pk.acc = make([]Summary, channels)
+	pk.accSq = make([]float64, channels)
+	pk.levels = make([][]Summary, 1)
+	return pk
+}
+
+/* add summarises freshly decoded (interleaved) samples */
+func (pk *Peaks) add(samps []int16) {
+	for _, s := range samps {
+		c := int(pk.nsamples % SampleN(pk.channels))
+		pk.nsamples++
+		if s < pk.acc[c].Min {
+			pk.acc[c].Min = s
+		}
+		if s > pk.acc[c].Max {
+			pk.acc[c].Max = s
+		}
+		pk.accSq[c] += float64(s) * float64(s)
+		if c == pk.channels - 1 {
+			pk.accN++
+			if pk.accN == 1 << peakBase {
+				pk.flush()
+			}
+		}
+	}
+}
+
+/* flush pushes the partial bucket onto level 0, and carries completed pairs up the pyramid */
+func (pk *Peaks) flush() {
+	if pk.accN == 0 {
+		return
+	}
+	pk.mu.Lock()
+	defer pk.mu.Unlock()
+	for c := range pk.acc {
+		pk.acc[c].MeanSq = float32(pk.accSq[c] / float64(pk.accN))
+		pk.levels[0] = append(pk.levels[0], pk.acc[c])
+		pk.acc[c], pk.accSq[c] = Summary{}, 0
+	}
+	pk.nframes += FrameN(pk.accN)
+	pk.accN = 0
+	for l := 0; ; l++ {
+		n := len(pk.levels[l]) / pk.channels
+		if n % 2 != 0 {
+			break
+		}
+		if l + 1 == len(pk.levels) {
+			pk.levels = append(pk.levels, nil)
+		}
+		a, b := pk.levels[l][(n-2)*pk.channels:(n-1)*pk.channels], pk.levels[l][(n-1)*pk.channels:]
+		for c := 0; c < pk.channels; c++ {
+			s := a[c]
+			s.merge(b[c])
+			pk.levels[l+1] = append(pk.levels[l+1], s)
+		}
+	}
+}
+
+/* Extents summarises each channel over frames f0 to fN (inclusive). Returns
+ * false if the span is too short to benefit from the pyramid. Frames which
+ * haven't been decoded yet are treated as silence. */
+func (pk *Peaks) Extents(f0, fN FrameN) ([]Summary, bool) {
+	if f0 < 0 {
+		f0 = 0
+	}
+	if fN - f0 + 1 < 2 << peakBase {
+		return nil, false
+	}
+	pk.mu.RLock()
+	defer pk.mu.RUnlock()
+	if fN >= pk.nframes {
+		fN = pk.nframes - 1
+	}
+	if fN < f0 {
+		return make([]Summary, pk.channels), true
+	}
+	/* coarsest level with at least two buckets per span */
+	l := 0
+	for l + 1 < len(pk.levels) && (fN - f0 + 1) >= 4 << uint(peakBase + l) {
+		l++
+	}
+	ext := make([]Summary, pk.channels)
+	first := true
+	pk.extent(l, f0, fN, ext, &first)
+	return ext, true
+}
+
+func (pk *Peaks) extent(l int, f0, fN FrameN, ext []Summary, first *bool) {
+	shift := uint(peakBase + l)
+	level := pk.levels[l]
+	i0, iN := int(f0 >> shift), int(fN >> shift)
+	for i := i0; i <= iN; i++ {
+		if (i + 1) * pk.channels > len(level) {
+			/* trailing buckets which haven't been carried up to this level yet */
+			if l > 0 {
+				pk.extent(l - 1, FrameN(i) << shift, fN, ext, first)
+			}
+			return
+		}
+		for c := 0; c < pk.channels; c++ {
+			if *first {
+				ext[c] = level[i*pk.channels + c]
+			} else {
+				ext[c].merge(level[i*pk.channels + c])
+			}
+		}
+		*first = false
+	}
+}
+
+/* Write saves the pyramid's level 0 (higher levels are rebuilt on load) */
+func (pk *Peaks) Write(filename string) error {
+	pk.mu.RLock()
+	defer pk.mu.RUnlock()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	hdr := []interface{}{[]byte(peakMagic), uint32(peakVersion), uint32(pk.channels), uint32(peakBase), int64(pk.nframes)}
+	for _, x := range hdr {
+		if err = binary.Write(w, binary.LittleEndian, x); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = binary.Write(w, binary.LittleEndian, pk.levels[0]); err == nil {
+		err = w.Flush()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+/* ReadPeaks loads a pyramid saved by Write */
+func ReadPeaks(filename string) (*Peaks, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(peakMagic))
+	var version, channels, base uint32
+	var nframes int64
+	if _, err = io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	for _, x := range []interface{}{&version, &channels, &base, &nframes} {
+		if err = binary.Read(r, binary.LittleEndian, x); err != nil {
+			return nil, err
+		}
+	}
+	if string(magic) != peakMagic || version != peakVersion || base != peakBase || channels == 0 {
+		return nil, errors.New("unrecognised peak file " + filename)
+	}
+	n := (nframes + 1 << peakBase - 1) >> peakBase
+	level0 := make([]Summary, n * int64(channels))
+	if err = binary.Read(r, binary.LittleEndian, level0); err != nil {
+		return nil, err
+	}
+	pk := newPeaks(int(channels))
+	for i := int64(0); i < n; i++ {
+		copy(pk.acc, level0[i*int64(channels):(i+1)*int64(channels)])
+		pk.accN = 1 << peakBase
+		if i == n - 1 {
+			pk.accN = int(nframes - i << peakBase)
+		}
+		for c := range pk.accSq {
+			pk.accSq[c] = float64(pk.acc[c].MeanSq) * float64(pk.accN)
+		}
+		pk.flush()
+	}
+	return pk, nil
+}
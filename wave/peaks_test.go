@@ -0,0 +1,74 @@
+package wave
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+/* stereo ramp; left counts up, right counts down */
+func ramp(nframes int) []int16 {
+	samps := make([]int16, 2*nframes)
+	for i := 0; i < nframes; i++ {
+		samps[2*i] = int16(i % 30000)
+		samps[2*i+1] = -int16(i % 30000)
+	}
+	return samps
+}
+
+func checkExtents(t *testing.T, pk *Peaks, samps []int16, f0, fN FrameN) {
+	ext, ok := pk.Extents(f0, fN)
+	if !ok {
+		t.Fatalf("no extents for %d-%d", f0, fN)
+	}
+	/* buckets may overhang the span, but must cover it */
+	for c := 0; c < 2; c++ {
+		for f := f0; f <= fN; f++ {
+			s := samps[int(f)*2 + c]
+			if s < ext[c].Min || s > ext[c].Max {
+				t.Errorf("%d-%d: channel %d sample %d at %d outside [%d,%d]", f0, fN, c, s, f, ext[c].Min, ext[c].Max)
+				return
+			}
+		}
+	}
+}
+
+func TestPeaks(t *testing.T) {
+	nframes := 100000
+	samps := ramp(nframes)
+	pk := newPeaks(2)
+	/* feed in uneven pieces, as a decoder would */
+	for i := 0; i < len(samps); i += 999 {
+		end := i + 999
+		if end > len(samps) {
+			end = len(samps)
+		}
+		pk.add(samps[i:end])
+	}
+	pk.flush()
+	if pk.nframes != FrameN(nframes) {
+		t.Fatalf("summarised %d frames, expected %d", pk.nframes, nframes)
+	}
+	if _, ok := pk.Extents(0, 10); ok {
+		t.Errorf("short span should fall back to samples")
+	}
+	checkExtents(t, pk, samps, 0, FrameN(nframes - 1))
+	checkExtents(t, pk, samps, 1234, 5678)
+	checkExtents(t, pk, samps, FrameN(nframes - 3000), FrameN(nframes - 1))
+
+	file := filepath.Join(os.TempDir(), "sqribe-peaks-test.peaks")
+	defer os.Remove(file)
+	if err := pk.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	pk2, err := ReadPeaks(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk2.nframes != pk.nframes || len(pk2.levels) != len(pk.levels) {
+		t.Fatalf("reloaded %d frames/%d levels, expected %d/%d", pk2.nframes, len(pk2.levels), pk.nframes, len(pk.levels))
+	}
+	checkExtents(t, pk2, samps, 0, FrameN(nframes - 1))
+}
@@ -0,0 +1,217 @@
+package wave
+
+import (
+	"io"
+	"math"
+)
+
+/* Convert adapts a source's channel count and sample rate. If the source
+ * already has the requested format it is returned as is; a rate of zero
+ * leaves the rate alone. */
+func Convert(src Source, rate, channels int) Source {
+	r, ch := src.Format()
+	if ch != channels {
+		src = &remix{src, ch, channels}
+	}
+	if rate != 0 && r != rate {
+		src = newResampled(src, rate)
+	}
+	return src
+}
+
+/* remix converts between channel counts. mono is copied to every output
+ * channel, anything to mono is averaged, and otherwise channels are copied
+ * across in order with any surplus dropped (or silent). */
+type remix struct {
+	Source
+	from, to int
+}
+
+func (m *remix) Format() (int, int) {
+	rate, _ := m.Source.Format()
+	return rate, m.to
+}
+
+func (m *remix) Progress() float64 {
+	return Progress(m.Source)
+}
+
+func (m *remix) Read() ([]int16, error) {
+	in, err := m.Source.Read()
+	nframes := len(in) / m.from
+	out := make([]int16, nframes * m.to)
+	for f := 0; f < nframes; f++ {
+		frame := in[f*m.from:(f+1)*m.from]
+		switch {
+		case m.to == 1:
+			sum := 0
+			for _, s := range frame {
+				sum += int(s)
+			}
+			out[f] = int16(sum / m.from)
+		case m.from == 1:
+			for c := 0; c < m.to; c++ {
+				out[f*m.to + c] = frame[0]
+			}
+		default:
+			copy(out[f*m.to:(f+1)*m.to], frame)
+		}
+	}
+	return out, err
+}
+
+const (
+	resampleLobes = 8 // zero crossings either side of the windowed sinc kernel
+	tableRes = 256 // kernel table entries per input frame
+)
+
+/* Resampler converts a stream of interleaved float samples between rates
+ * using a Hann windowed sinc. The kernel is tabulated up front so it's cheap
+ * enough to run in the playback path. Output frame k corresponds exactly to
+ * input frame k*ratio; the trailing half-width of input is held back until
+ * enough follows it, or until Flush. */
+type Resampler struct {
+	channels int
+	ratio float64 // input frames per output frame
+	width int // kernel half-width in input frames
+	table []float64
+
+	buf []float32 // pending interleaved input
+	t float64 // position of the next output frame within buf
+	acc []float64
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi * x) / (math.Pi * x)
+}
+
+func NewResampler(from, to, channels int) *Resampler {
+	rs := &Resampler{channels: channels, ratio: float64(from) / float64(to)}
+	fc := math.Min(1, 1 / rs.ratio) // cutoff, relative to the input nyquist
+	rs.width = int(math.Ceil(resampleLobes / fc))
+	w := float64(rs.width)
+	rs.table = make([]float64, rs.width * tableRes + 2)
+	for i := range rs.table {
+		x := float64(i) / tableRes
+		if x < w {
+			rs.table[i] = fc * sinc(fc * x) * (0.5 + 0.5 * math.Cos(math.Pi * x / w))
+		}
+	}
+	/* prime with silence so the first output frame has history */
+	rs.buf = make([]float32, rs.width * channels)
+	rs.t = w
+	rs.acc = make([]float64, channels)
+	return rs
+}
+
+func (rs *Resampler) kernel(x float64) float64 {
+	x = math.Abs(x) * tableRes
+	i := int(x)
+	if i + 1 >= len(rs.table) {
+		return 0
+	}
+	f := x - float64(i)
+	return rs.table[i] * (1 - f) + rs.table[i + 1] * f
+}
+
+/* Process consumes in and appends whatever output it can produce to out */
+func (rs *Resampler) Process(in, out []float32) []float32 {
+	nc := rs.channels
+	rs.buf = append(rs.buf, in...)
+	n := len(rs.buf) / nc
+	for int(rs.t) + rs.width < n {
+		for c := range rs.acc {
+			rs.acc[c] = 0
+		}
+		for i := int(rs.t) - rs.width + 1; i <= int(rs.t) + rs.width; i++ {
+			k := rs.kernel(rs.t - float64(i))
+			for c := 0; c < nc; c++ {
+				rs.acc[c] += k * float64(rs.buf[i*nc + c])
+			}
+		}
+		for _, x := range rs.acc {
+			out = append(out, float32(x))
+		}
+		rs.t += rs.ratio
+	}
+	/* drop input which is no longer needed */
+	drop := int(rs.t) - rs.width + 1
+	if drop > n {
+		drop = n
+	}
+	if drop > 0 {
+		rs.buf = append(rs.buf[:0], rs.buf[drop*nc:]...)
+		rs.t -= float64(drop)
+	}
+	return out
+}
+
+/* Flush appends the output still held back at the end of the stream to out.
+ * Nothing more should be passed to Process afterwards. */
+func (rs *Resampler) Flush(out []float32) []float32 {
+	out = rs.Process(make([]float32, (rs.width + 1) * rs.channels), out)
+	rs.buf = rs.buf[:0]
+	return out
+}
+
+/* resampled adapts a Source to another rate */
+type resampled struct {
+	Source
+	rate, channels int
+	rs *Resampler
+	in, out []float32
+	eof bool
+}
+
+func newResampled(src Source, rate int) *resampled {
+	in, channels := src.Format()
+	return &resampled{Source: src, rate: rate, channels: channels, rs: NewResampler(in, rate, channels)}
+}
+
+func (r *resampled) Format() (int, int) {
+	return r.rate, r.channels
+}
+
+func (r *resampled) Progress() float64 {
+	return Progress(r.Source)
+}
+
+func clip16(x float64) int16 {
+	x = math.Floor(x + 0.5)
+	if x > math.MaxInt16 {
+		return math.MaxInt16
+	} else if x < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(x)
+}
+
+func (r *resampled) Read() ([]int16, error) {
+	if r.eof {
+		return nil, io.EOF
+	}
+	r.out = r.out[:0]
+	for len(r.out) == 0 && !r.eof {
+		in, err := r.Source.Read()
+		r.in = r.in[:0]
+		for _, s := range in {
+			r.in = append(r.in, float32(s))
+		}
+		r.out = r.rs.Process(r.in, r.out)
+		if err == io.EOF {
+			/* the tail of the input is still held back */
+			r.eof = true
+			r.out = r.rs.Flush(r.out)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	samps := make([]int16, len(r.out))
+	for i, x := range r.out {
+		samps[i] = clip16(float64(x))
+	}
+	return samps, nil
+}
@@ -0,0 +1,34 @@
+package wave
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResampler(t *testing.T) {
+	in, out, freq := 44100, 48000, 1000.0
+	rs := NewResampler(in, out, 2)
+	var got []float32
+	/* feed in small blocks, as playback does */
+	n := 64 * (in / 128)
+	block := make([]float32, 2*64)
+	for f := 0; f < n; f += 64 {
+		for i := 0; i < 64; i++ {
+			x := float32(0.5 * math.Sin(2 * math.Pi * freq * float64(f + i) / float64(in)))
+			block[2*i], block[2*i + 1] = x, -x
+		}
+		got = rs.Process(block, got)
+	}
+	if expect := n * out / in; len(got)/2 < expect - 20 || len(got)/2 > expect {
+		t.Errorf("got %d frames, expected about %d", len(got)/2, expect)
+	}
+	worst := 0.0
+	for i := 500; i < len(got)/2; i++ {
+		ideal := 0.5 * math.Sin(2 * math.Pi * freq * float64(i) / float64(out))
+		worst = math.Max(worst, math.Abs(float64(got[2*i]) - ideal))
+		worst = math.Max(worst, math.Abs(float64(got[2*i + 1]) + ideal))
+	}
+	if worst > 0.005 {
+		t.Errorf("resampled sine deviates by up to %.4f", worst)
+	}
+}
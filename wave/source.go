@@ -0,0 +1,110 @@
+package wave
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/* A Source decodes an audio file into blocks of interleaved 16-bit samples.
+ * Read returns io.EOF once the stream is exhausted. */
+type Source interface {
+	Format() (rate, channels int)
+	Read() ([]int16, error)
+	Close() error
+}
+
+/* A Source which knows its length can report how far through it has read */
+type progressor interface {
+	Progress() float64
+}
+
+/* Progress returns how much of src has been read on [0,1], or -1 if unknown */
+func Progress(src Source) float64 {
+	if p, ok := src.(progressor); ok {
+		return p.Progress()
+	}
+	return -1
+}
+
+/* An Opener opens a file as a Source. rate and channels describe the format
+ * the caller ultimately wants; an Opener which can convert cheaply may honour
+ * them, but it's free to return the file's native format instead. A rate of
+ * zero asks for the native rate. */
+type Opener func(file string, rate, channels int) (Source, error)
+
+type decoder struct {
+	name string
+	exts []string // lowercase, without the dot. empty for fallback decoders
+	open Opener
+}
+
+var decoders struct {
+	sync.Mutex
+	list []decoder
+}
+
+/* Register makes a decoder available to OpenSource. Decoders with extensions
+ * are tried first for matching files; those without are tried for any file,
+ * in the order they were registered. */
+func Register(name string, open Opener, exts... string) {
+	decoders.Lock()
+	defer decoders.Unlock()
+	for i := range exts {
+		exts[i] = strings.ToLower(exts[i])
+	}
+	decoders.list = append(decoders.list, decoder{name, exts, open})
+}
+
+func (d decoder) handles(ext string) bool {
+	for _, e := range d.exts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+/* OpenSource finds a decoder for file and converts its output to the
+ * requested format. If rate is zero the file's native rate is kept. */
+func OpenSource(file string, rate, channels int) (Source, error) {
+	decoders.Lock()
+	list := decoders.list
+	decoders.Unlock()
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+	candidates := make([]decoder, 0, len(list))
+	for _, d := range list {
+		if d.handles(ext) {
+			candidates = append(candidates, d)
+		}
+	}
+	for _, d := range list {
+		if len(d.exts) == 0 {
+			candidates = append(candidates, d)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%s: no decoder available", file)
+	}
+	errs := make([]string, 0, len(candidates))
+	for _, d := range candidates {
+		src, err := d.open(file, rate, channels)
+		if err == nil {
+			return Convert(src, rate, channels), nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", d.name, err))
+	}
+	return nil, fmt.Errorf("%s: %s", file, strings.Join(errs, "; "))
+}
+
+/* Extensions lists the file extensions with a dedicated decoder */
+func Extensions() []string {
+	decoders.Lock()
+	defer decoders.Unlock()
+	exts := make([]string, 0, 8)
+	for _, d := range decoders.list {
+		exts = append(exts, d.exts...)
+	}
+	return exts
+}
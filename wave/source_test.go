@@ -0,0 +1,251 @@
+package wave
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/sqweek/sqribe/core/types"
+)
+
+/* sliceSource serves samples from memory in fixed size blocks */
+type sliceSource struct {
+	samps []int16
+	rate, channels, block int
+}
+
+func (s *sliceSource) Format() (int, int) {
+	return s.rate, s.channels
+}
+
+func (s *sliceSource) Read() ([]int16, error) {
+	if len(s.samps) == 0 {
+		return nil, io.EOF
+	}
+	n := s.block * s.channels
+	if n > len(s.samps) {
+		n = len(s.samps)
+	}
+	out := s.samps[:n]
+	s.samps = s.samps[n:]
+	return out, nil
+}
+
+func (s *sliceSource) Close() error {
+	return nil
+}
+
+func readAll(t *testing.T, src Source) []int16 {
+	all := make([]int16, 0, 1024)
+	for {
+		samps, err := src.Read()
+		all = append(all, samps...)
+		if err == io.EOF {
+			return all
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tempFile(t *testing.T, name string, data []byte) string {
+	dir, err := ioutil.TempDir("", "sqribe-wave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(file, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func mkWAV(format, channels, rate, bits int, data []byte) []byte {
+	le := binary.LittleEndian
+	buf := make([]byte, 44, 44 + len(data))
+	copy(buf[0:], "RIFF")
+	le.PutUint32(buf[4:], uint32(36 + len(data)))
+	copy(buf[8:], "WAVEfmt ")
+	le.PutUint32(buf[16:], 16)
+	le.PutUint16(buf[20:], uint16(format))
+	le.PutUint16(buf[22:], uint16(channels))
+	le.PutUint32(buf[24:], uint32(rate))
+	le.PutUint32(buf[28:], uint32(rate * channels * bits / 8))
+	le.PutUint16(buf[32:], uint16(channels * bits / 8))
+	le.PutUint16(buf[34:], uint16(bits))
+	copy(buf[36:], "data")
+	le.PutUint32(buf[40:], uint32(len(data)))
+	return append(buf, data...)
+}
+
+func TestWAV(t *testing.T) {
+	want := []int16{0, 1, -1, 32767, -32768, 1234, -4321, 7}
+	data := make([]byte, 2*len(want))
+	for i, s := range want {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+	file := tempFile(t, "pcm.wav", mkWAV(wavPCM, 2, 22050, 16, data))
+	defer os.RemoveAll(filepath.Dir(file))
+	src, err := OpenSource(file, 22050, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	got := readAll(t, src)
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sample %d: got %d, expected %d", i, got[i], want[i])
+		}
+	}
+
+	/* float mono, upmixed to stereo */
+	floats := []float32{0, 0.5, -0.5, 2}
+	data = make([]byte, 4*len(floats))
+	for i, x := range floats {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(x))
+	}
+	file2 := tempFile(t, "float.wav", mkWAV(wavFloat, 1, 22050, 32, data))
+	defer os.RemoveAll(filepath.Dir(file2))
+	src, err = OpenSource(file2, 22050, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	got = readAll(t, src)
+	want = []int16{0, 0, 16384, 16384, -16384, -16384, 32767, 32767}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("float sample %d: got %d, expected %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWAVWriter(t *testing.T) {
+	file := tempFile(t, "out.wav", nil)
+	defer os.RemoveAll(filepath.Dir(file))
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wr, err := NewWAVWriter(f, 48000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range [][]float32{{0, 0.5}, {-0.5, 1.5, -2, 0.25}} {
+		if err = wr.Write(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	src, err := OpenSource(file, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if rate, _ := src.Format(); rate != 48000 {
+		t.Errorf("rate %d, expected 48000", rate)
+	}
+	got := readAll(t, src)
+	want := []int16{0, 16384, -16384, 32767, -32768, 8192}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sample %d: got %d, expected %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	in, out, freq := 48000, 44100, 1000.0
+	n := in / 2
+	samps := make([]int16, n)
+	for i := range samps {
+		samps[i] = int16(10000 * math.Sin(2 * math.Pi * freq * float64(i) / float64(in)))
+	}
+	src := Convert(&sliceSource{samps, in, 1, 1000}, out, 1)
+	got := readAll(t, src)
+	if expect := n * out / in; len(got) < expect - 2 || len(got) > expect + 2 {
+		t.Errorf("got %d frames, expected %d", len(got), expect)
+	}
+	/* compare against the ideal output away from the edges */
+	worst := 0.0
+	for i := 500; i < len(got) - 500; i++ {
+		ideal := 10000 * math.Sin(2 * math.Pi * freq * float64(i) / float64(out))
+		worst = math.Max(worst, math.Abs(float64(got[i]) - ideal))
+	}
+	if worst > 50 {
+		t.Errorf("resampled sine deviates by up to %.0f", worst)
+	}
+}
+
+func TestWaveformFrom(t *testing.T) {
+	nframes := 300000
+	samps := make([]int16, 2*nframes)
+	for i := range samps {
+		samps[i] = int16(i % 1000 - 500)
+	}
+	samps[12345] = 9999
+	dir, err := ioutil.TempDir("", "sqribe-wave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachefile := filepath.Join(dir, "cache")
+	reply := make(chan error, 1)
+	wav := NewWaveformFrom(context.Background(), &sliceSource{append([]int16(nil), samps...), 44100, 2, 4096}, "test", cachefile, nil, reply)
+	if err := <-reply; err != nil {
+		t.Fatal(err)
+	}
+	if wav.NSamples != SampleN(len(samps)) || wav.Max[1] != 9999 {
+		t.Errorf("got %d samples max %v, expected %d/9999", wav.NSamples, wav.Max, len(samps))
+	}
+	got := wav.Frames(100000, 200000)
+	for i, s := range got {
+		if s != samps[200000 + i] {
+			t.Fatalf("sample %d: got %d, expected %d", 200000 + i, s, samps[200000 + i])
+		}
+	}
+	wav.Close()
+}
+
+/* endless produces silence forever */
+type endless struct{}
+
+func (endless) Format() (int, int) { return 44100, 2 }
+func (endless) Read() ([]int16, error) { return make([]int16, 8192), nil }
+func (endless) Close() error { return nil }
+
+func TestWaveformCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqribe-wave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachefile := filepath.Join(dir, "cache")
+	reply := make(chan error, 1)
+	wav := NewWaveformFrom(context.Background(), endless{}, "endless", cachefile, nil, reply)
+	wav.Frames(0, 1000) // wait for decoding to get underway
+	wav.Close()
+	if err := <-reply; err != context.Canceled {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+	if _, err := os.Stat(cachefile); !os.IsNotExist(err) {
+		t.Errorf("incomplete cache left behind: %v", err)
+	}
+}
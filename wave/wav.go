@@ -0,0 +1,179 @@
+package wave
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+func init() {
+	Register("wav", openWAV, "wav", "wave")
+}
+
+const (
+	wavPCM = 1
+	wavFloat = 3
+	wavExtensible = 0xfffe
+	wavBlockFrames = 4096
+)
+
+/* wavReader decodes RIFF WAVE files holding integer PCM or IEEE float samples */
+type wavReader struct {
+	f *os.File
+	r *bufio.Reader
+	rate, channels int
+	format int
+	bits int
+	remaining int64 // bytes of sample data left
+	size int64 // bytes of sample data in total
+}
+
+func openWAV(file string, rate, channels int) (Source, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	wav := &wavReader{f: f, r: bufio.NewReader(f)}
+	if err = wav.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("wav: %v", err)
+	}
+	return wav, nil
+}
+
+func (wav *wavReader) readHeader() error {
+	var riff [12]byte
+	if _, err := io.ReadFull(wav.r, riff[:]); err != nil {
+		return err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return errors.New("not a RIFF WAVE file")
+	}
+	gotFmt := false
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(wav.r, hdr[:]); err != nil {
+			if err == io.EOF {
+				err = errors.New("no data chunk")
+			}
+			return err
+		}
+		id, size := string(hdr[0:4]), int64(binary.LittleEndian.Uint32(hdr[4:8]))
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return errors.New("short fmt chunk")
+			}
+			buf := make([]byte, size + size % 2)
+			if _, err := io.ReadFull(wav.r, buf); err != nil {
+				return err
+			}
+			wav.format = int(binary.LittleEndian.Uint16(buf[0:2]))
+			wav.channels = int(binary.LittleEndian.Uint16(buf[2:4]))
+			wav.rate = int(binary.LittleEndian.Uint32(buf[4:8]))
+			wav.bits = int(binary.LittleEndian.Uint16(buf[14:16]))
+			if wav.format == wavExtensible && size >= 40 {
+				/* first two bytes of the subformat GUID hold the real format tag */
+				wav.format = int(binary.LittleEndian.Uint16(buf[24:26]))
+			}
+			gotFmt = true
+		case "data":
+			if !gotFmt {
+				return errors.New("data chunk before fmt chunk")
+			}
+			wav.remaining, wav.size = size, size
+			return wav.checkFormat()
+		default:
+			if _, err := io.CopyN(ioutil.Discard, wav.r, size + size % 2); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (wav *wavReader) checkFormat() error {
+	if wav.channels < 1 || wav.rate < 1 {
+		return fmt.Errorf("bad format: %dHz %d channels", wav.rate, wav.channels)
+	}
+	switch {
+	case wav.format == wavPCM && (wav.bits == 8 || wav.bits == 16 || wav.bits == 24 || wav.bits == 32):
+	case wav.format == wavFloat && (wav.bits == 32 || wav.bits == 64):
+	default:
+		return fmt.Errorf("unsupported encoding %d with %d bits per sample", wav.format, wav.bits)
+	}
+	return nil
+}
+
+func (wav *wavReader) Format() (int, int) {
+	return wav.rate, wav.channels
+}
+
+func (wav *wavReader) Progress() float64 {
+	if wav.size == 0 {
+		return 1
+	}
+	return 1 - float64(wav.remaining) / float64(wav.size)
+}
+
+func (wav *wavReader) sample(b []byte) int16 {
+	switch wav.format {
+	case wavPCM:
+		switch wav.bits {
+		case 8:
+			return int16(int(b[0]) - 128) << 8
+		case 16:
+			return int16(binary.LittleEndian.Uint16(b))
+		case 24:
+			return int16(uint16(b[1]) | uint16(b[2]) << 8)
+		case 32:
+			return int16(binary.LittleEndian.Uint32(b) >> 16)
+		}
+	case wavFloat:
+		var x float64
+		if wav.bits == 32 {
+			x = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		} else {
+			x = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return clip16(x * 32768)
+	}
+	return 0
+}
+
+func (wav *wavReader) Read() ([]int16, error) {
+	width := wav.bits / 8
+	frame := int64(width * wav.channels)
+	n := int64(wavBlockFrames) * frame
+	if n > wav.remaining {
+		n = wav.remaining - wav.remaining % frame
+	}
+	if n <= 0 {
+		return nil, io.EOF
+	}
+	buf := make([]byte, n)
+	nread, err := io.ReadFull(wav.r, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		/* data chunk size overstated (common with streamed recordings) */
+		buf = buf[:int64(nread) - int64(nread) % frame]
+		wav.remaining = 0
+	} else if err != nil {
+		return nil, err
+	} else {
+		wav.remaining -= n
+	}
+	n = int64(len(buf))
+	samps := make([]int16, int(n) / width)
+	for i := range samps {
+		samps[i] = wav.sample(buf[i*width:])
+	}
+	return samps, nil
+}
+
+func (wav *wavReader) Close() error {
+	return wav.f.Close()
+}
@@ -1,11 +1,12 @@
 package wave
 
 import (
+	"context"
+	"os"
 	"time"
-	"github.com/sqweek/ffau"
 
-	"github.com/sqweek/sqribe/audio"
 	"github.com/sqweek/sqribe/log"
+	"github.com/sqweek/sqribe/plumb"
 	. "github.com/sqweek/sqribe/core/types"
 )
 
@@ -16,37 +17,62 @@ type Waveform struct {
 	Max []int16 // maximum amplitudes for each channel
 
 	cache *cache
+	peaks *Peaks
+
+	cancel context.CancelFunc // stops decoding
+	decoding chan struct{} // closed once the decoder has finished
 }
 
-func NewWaveform(file, cachefile string, reply chan<- error) (*Waveform, error) {
-	wave := &Waveform{rate: audio.SampleRate, Channels: audio.Channels, NSamples: 0}
-	wave.cache = mkcache(1024*1024, 2, cachefile)
-	wave.Max = make([]int16, wave.Channels)
-	ctx, err := ffau.OpenFile(file)
-	if err != nil {
-		return nil, err
-	}
-	raw, err := ctx.OpenAudioStream()
-	if err != nil {
-		return nil, err
-	}
-	log.WAV.Println("raw audiostream format", raw.Format())
-	desired := ffau.AudioFormat{wave.rate, ffau.PackedS16s, ffau.DefaultLayout(wave.Channels)}
-	converted, err := ffau.Resample(raw, desired)
+/* PeakFile returns the name of the peak pyramid stored alongside a cache file */
+func PeakFile(cachefile string) string {
+	return cachefile + ".peaks"
+}
+
+/* DecodeProgress is published while a Waveform is being decoded */
+type DecodeProgress struct {
+	Wave *Waveform
+	Samples SampleN // decoded so far
+	Fraction float64 // on [0,1], or -1 if the source's length is unknown
+	Done bool
+	Err error // set when Done, if decoding failed or was cancelled
+}
+
+const progressInterval = 100 * time.Millisecond
+
+/* NewWaveform decodes an audio file into cachefile, converting it to the
+ * given sample rate (zero keeps the file's own) and channel count. Decoding continues in the background
+ * until it completes or ctx is cancelled; its outcome is sent on reply. */
+func NewWaveform(ctx context.Context, file, cachefile string, rate, channels int, progress *plumb.Port, reply chan<- error) (*Waveform, error) {
+	src, err := OpenSource(file, rate, channels)
 	if err != nil {
 		return nil, err
 	}
-	log.WAV.Println("converted audiostream format", converted.Format())
-	reader, err := ffau.NewPackedS16Stream(converted)
-	if err != nil {
-		return nil, err
+	return NewWaveformFrom(ctx, src, file, cachefile, progress, reply), nil
+}
+
+/* NewWaveformFrom decodes an already open source; name is only used for
+ * logging. progress may be nil. */
+func NewWaveformFrom(ctx context.Context, src Source, name, cachefile string, progress *plumb.Port, reply chan<- error) *Waveform {
+	wave := &Waveform{NSamples: 0}
+	wave.rate, wave.Channels = src.Format()
+	ctx, wave.cancel = context.WithCancel(ctx)
+	wave.decoding = make(chan struct{})
+	os.Remove(MetaFile(cachefile)) // any previous cache here is about to be overwritten
+	wave.cache = mkcache(1024*1024, 2, cachefile)
+	wave.Max = make([]int16, wave.Channels)
+	wave.peaks = newPeaks(wave.Channels)
+	publish := func(done bool, err error) {
+		if progress != nil {
+			progress.C <- DecodeProgress{wave, wave.NSamples, Progress(src), done, err}
+		}
 	}
 	go func() {
+		lastPublish := time.Now()
 		decode := func() ([]int16, error) {
-			samps, err := reader.Read()
-			if err != nil {
-				return samps, err
+			if err := ctx.Err(); err != nil {
+				return nil, err
 			}
+			samps, err := src.Read()
 			if len(samps) > 0 {
 				for i := 0; i < len(samps); i++ {
 					c := int((wave.NSamples + SampleN(i)) % SampleN(wave.Channels))
@@ -56,42 +82,77 @@ func NewWaveform(file, cachefile string, reply chan<- error) (*Waveform, error)
 						wave.Max[c] = -samps[i]
 					}
 				}
+				wave.peaks.add(samps)
 				wave.NSamples += SampleN(len(samps))
 			}
-			return samps, nil
+			if time.Since(lastPublish) > progressInterval {
+				publish(false, nil)
+				lastPublish = time.Now()
+			}
+			return samps, err
 		}
 		err := wave.cache.Write(decode)
-		reply <- err
-		if err != nil {
-			log.WAV.Printf("decoding error %d samples into %s: %v", wave.NSamples, file, err)
+		wave.peaks.flush()
+		src.Close()
+		publish(true, err)
+		if ctx.Err() != nil {
+			log.WAV.Printf("decoding %s cancelled after %d samples", name, wave.NSamples)
+			/* an incomplete cache is no use to anyone */
+			for _, f := range CacheFiles(cachefile) {
+				os.Remove(f)
+			}
+		} else if err != nil {
+			log.WAV.Printf("decoding error %d samples into %s: %v", wave.NSamples, name, err)
+		} else if err := wave.peaks.Write(PeakFile(cachefile)); err != nil {
+			log.WAV.Printf("writing peaks for %s: %v", name, err)
+		} else if err := wave.writeMeta(cachefile); err != nil {
+			/* the meta file marks the cache complete, so it goes last */
+			log.WAV.Printf("writing cache metadata for %s: %v", name, err)
 		}
-		converted.Close()
-		ctx.Close()
+		close(wave.decoding)
+		reply <- err
 	}()
 
-	return wave, nil
+	return wave
 }
 
+/* Close stops any decoding still in progress and releases the cache */
 func (wav *Waveform) Close() {
-	// wait for decoder to finish, if it hasn't already
-	for wav.cache.bytesWritten != -1 {
-		time.Sleep(100 * time.Millisecond)
+	if wav.cancel != nil {
+		wav.cancel()
+		<-wav.decoding
 	}
 	wav.cache.Close()
 }
 
-func (wav *Waveform) ChannelExtents(samples []int16) []int16 {
-	minMax := make([]int16, wav.Channels * 2)
-	for i := 0; i < len(samples); i += wav.Channels {
+/* Wait blocks until decoding has finished, successfully or not */
+func (wav *Waveform) Wait() {
+	if wav.decoding != nil {
+		<-wav.decoding
+	}
+}
+
+/* Summarise computes the same per-channel summary as Extents, directly from samples */
+func (wav *Waveform) Summarise(samples []int16) []Summary {
+	ext := make([]Summary, wav.Channels)
+	sumsq := make([]float64, wav.Channels)
+	for i := 0; i + wav.Channels <= len(samples); i += wav.Channels {
 		for j := 0; j < wav.Channels; j++ {
-			if samples[i + j] < minMax[j*2] {
-				minMax[j*2] = samples[i + j]
-			} else if samples[i + j] > minMax[j*2 + 1] {
-				minMax[j*2 + 1] = samples[i + j]
+			s := samples[i + j]
+			if s < ext[j].Min {
+				ext[j].Min = s
+			} else if s > ext[j].Max {
+				ext[j].Max = s
 			}
+			sumsq[j] += float64(s) * float64(s)
+		}
+	}
+	if n := len(samples) / wav.Channels; n > 0 {
+		for j := range ext {
+			ext[j].MeanSq = float32(sumsq[j] / float64(n))
 		}
 	}
-	return minMax
+	return ext
 }
 
 func (chunk *Chunk) copy(samples []int16, i0 SampleN) {
@@ -114,6 +175,13 @@ func (chunk *Chunk) copy(samples []int16, i0 SampleN) {
 	copy(samples[s0:sN], chunk.Data[c0:cN])
 }
 
+/* Extents summarises each channel over frames f0 to fN (inclusive) using the
+ * peak pyramid. Returns false if the pyramid can't help, in which case the
+ * caller should fall back to examining samples. */
+func (wav *Waveform) Extents(f0, fN FrameN) ([]Summary, bool) {
+	return wav.peaks.Extents(f0, fN)
+}
+
 /* Blocks until frames from f0 to fN (inclusive) have been read from disk */
 func (wav *Waveform) Frames(f0, fN FrameN) []int16 {
 	if fN < f0 {
@@ -138,6 +206,24 @@ func (wav *Waveform) Frames(f0, fN FrameN) []int16 {
 	return samples
 }
 
+/* Like Frames, but scales the samples to floats on [-1,1) */
+func (wav *Waveform) FloatFrames(f0, fN FrameN) []float32 {
+	return Float(wav.Frames(f0, fN), nil)
+}
+
+/* Float converts 16-bit samples to floats on [-1,1), reusing out if it is
+ * large enough. */
+func Float(samples []int16, out []float32) []float32 {
+	if cap(out) < len(samples) {
+		out = make([]float32, len(samples))
+	}
+	out = out[:len(samples)]
+	for i, s := range samples {
+		out[i] = float32(s) / 32768
+	}
+	return out
+}
+
 func Extract(chunks []*Chunk, s0, sN SampleN) []int16 {
 	var samples []int16 = nil
 	for _, chunk := range(chunks) {
@@ -182,6 +268,10 @@ func (wav *Waveform) MaxAmp() int16 {
 	return m
 }
 
+func (wav *Waveform) Rate() int {
+	return wav.rate
+}
+
 func (wav *Waveform) TimeAtFrame(frame FrameN) time.Duration {
 	secs := float64(frame) / float64(wav.rate)
 	return time.Duration(secs * 1000000) * time.Microsecond
@@ -221,7 +311,7 @@ func (wav *Waveform) Clip(f FrameN, inset FrameN) FrameN {
 	if f < 0 {
 		return 0
 	}
-	if wav.cache.bytesWritten == -1 {
+	if wav.cache.complete() {
 		max := wav.ToFrame(wav.NSamples)
 		if f + inset > max {
 			return max - inset
@@ -0,0 +1,92 @@
+package wave
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+/* WAVWriter writes 16-bit PCM WAV files. The header's sizes aren't known
+ * until the end, so they're filled in by Close and the destination must be
+ * seekable. */
+type WAVWriter struct {
+	w io.WriteSeeker
+	channels int
+	size uint32 // bytes of sample data written
+	buf []byte
+}
+
+func NewWAVWriter(w io.WriteSeeker, rate, channels int) (*WAVWriter, error) {
+	wr := &WAVWriter{w: w, channels: channels}
+	if _, err := w.Write(wr.header(rate)); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+func (wr *WAVWriter) header(rate int) []byte {
+	hdr := make([]byte, 44)
+	le := binary.LittleEndian
+	copy(hdr[0:], "RIFF")
+	le.PutUint32(hdr[4:], 36 + wr.size)
+	copy(hdr[8:], "WAVEfmt ")
+	le.PutUint32(hdr[16:], 16)
+	le.PutUint16(hdr[20:], wavPCM)
+	le.PutUint16(hdr[22:], uint16(wr.channels))
+	le.PutUint32(hdr[24:], uint32(rate))
+	le.PutUint32(hdr[28:], uint32(rate * wr.channels * 2))
+	le.PutUint16(hdr[32:], uint16(wr.channels * 2))
+	le.PutUint16(hdr[34:], 16)
+	copy(hdr[36:], "data")
+	le.PutUint32(hdr[40:], wr.size)
+	return hdr
+}
+
+/* Write appends interleaved samples on [-1,1]; anything outside is clipped */
+func (wr *WAVWriter) Write(samples []float32) error {
+	if cap(wr.buf) < 2*len(samples) {
+		wr.buf = make([]byte, 2*len(samples))
+	}
+	buf := wr.buf[:2*len(samples)]
+	for i, x := range samples {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(clip16(float64(x) * 32768)))
+	}
+	n, err := wr.w.Write(buf)
+	wr.size += uint32(n)
+	return err
+}
+
+/* WriteS16 appends interleaved 16-bit samples */
+func (wr *WAVWriter) WriteS16(samples []int16) error {
+	if cap(wr.buf) < 2*len(samples) {
+		wr.buf = make([]byte, 2*len(samples))
+	}
+	buf := wr.buf[:2*len(samples)]
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(s))
+	}
+	n, err := wr.w.Write(buf)
+	wr.size += uint32(n)
+	return err
+}
+
+/* Close rewrites the header with the final sizes. It doesn't close the
+ * underlying writer. */
+func (wr *WAVWriter) Close() error {
+	if _, err := wr.w.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	var sz [4]byte
+	binary.LittleEndian.PutUint32(sz[:], 36 + wr.size)
+	if _, err := wr.w.Write(sz[:]); err != nil {
+		return err
+	}
+	if _, err := wr.w.Seek(40, io.SeekStart); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(sz[:], wr.size)
+	if _, err := wr.w.Write(sz[:]); err != nil {
+		return err
+	}
+	_, err := wr.w.Seek(0, io.SeekEnd)
+	return err
+}
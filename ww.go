@@ -64,7 +64,7 @@ func (p *noteProspect) mkNote(sc *score.Score, duration *big.Rat) (*score.Note,
 		}
 	}
 	/* no existing note found */
-	return &score.Note{p.staff.PitchForLine(p.delta), duration, beat, offset}, false
+	return &score.Note{p.staff.PitchForLine(p.delta), duration, beat, offset, false}, false
 }
 
 type noteDrag struct {
@@ -80,6 +80,13 @@ type mouseState struct {
 	rectSelect *image.Rectangle
 }
 
+/* WaveDisplay selects how the waveform is drawn */
+type WaveDisplay struct {
+	Split bool // each channel in its own lane, rather than overlaid
+	RMS bool // loudness envelope overlaid on the peaks
+	Log bool // decibel amplitude scale, so quiet passages are visible
+}
+
 type FramePos struct {
 	f0 FrameN
 	ppix int
@@ -100,6 +107,9 @@ type WaveWidget struct {
 	notesel map[*score.Note]*score.Staff
 	snarf map[*score.Staff] []*score.Note // the cut/copy buffer
 	pasteMode bool
+	suggest *Suggestion // ghost notes proposed by analysis
+	verify *Verification // transcription check results, shown over the notes
+	display WaveDisplay
 	beatdrag map[*score.BeatRef]FrameN
 
 	/* renderer related state */
@@ -432,9 +442,11 @@ func (ww *WaveWidget) Zoom(factor float64) float64 {
 		fpp = 1
 	}
 	if wav := ww.wav; wav != nil && ww.rect.wave.Dx() > 0 {
-		max_frames := (12*1024*1024 / 10 / 2 / 2) * 9
-		max_fpp := int(max_frames / ww.rect.wave.Dx())
-		if fpp > max_fpp {
+		/* the peak pyramid makes drawing cheap at any zoom, so just stop
+		 * once the whole song fits in half the widget */
+		max_frames := wave.Range(wav).MaxFrame() * 2
+		max_fpp := int(max_frames) / ww.rect.wave.Dx()
+		if max_fpp > 0 && fpp > max_fpp {
 			fpp = max_fpp
 		}
 	}
@@ -491,6 +503,49 @@ func (ww *WaveWidget) SetPasteMode(mode bool) {
 	ww.changed(SCALE, ww.snarf)
 }
 
+func (ww *WaveWidget) Suggestion() *Suggestion {
+	return ww.suggest
+}
+
+func (ww *WaveWidget) SetSuggestion(sugg *Suggestion) {
+	if ww.suggest == nil && sugg == nil {
+		return
+	}
+	ww.suggest = sugg
+	ww.changed(SCALE, sugg)
+}
+
+func (ww *WaveWidget) Verification() *Verification {
+	return ww.verify
+}
+
+func (ww *WaveWidget) SetVerification(v *Verification) {
+	if ww.verify == nil && v == nil {
+		return
+	}
+	ww.verify = v
+	ww.changed(SCALE, v)
+}
+
+func (ww *WaveWidget) Display() WaveDisplay {
+	return ww.display
+}
+
+func (ww *WaveWidget) SetDisplay(disp WaveDisplay) {
+	ww.display = disp
+	ww.changed(WAV, &ww.display)
+}
+
+/* AcceptSuggestion adds the suggested notes to their staves, as a single undoable op */
+func (ww *WaveWidget) AcceptSuggestion() {
+	sugg := ww.suggest
+	if sugg == nil || ww.score == nil {
+		return
+	}
+	ww.SetSuggestion(nil)
+	ww.score.AddStaffNotes(sugg.Notes...)
+}
+
 func (ww *WaveWidget) beatFrame(beat *score.BeatRef) FrameN {
 	if ww.beatdrag != nil {
 		if f, ok := ww.beatdrag[beat]; ok {
@@ -259,22 +259,48 @@ func colourFor(offset *big.Rat, α uint8) color.NRGBA {
 	return color.NRGBA{0x00, 0x00, 0x00, α}
 }
 
-func scale(chMin, chMax int16, yscale float64) (int, int) {
-	var min, max int
-	if chMin < 0 {
-		min = int(float64(chMin) / yscale)
+/* waveScale maps sample values to pixel offsets from a lane's centre line */
+type waveScale struct {
+	half int // pixels from the centre line to the edge of the lane
+	max float64 // sample value which reaches the edge
+	log bool
+}
+
+const waveFloorDB = -60.0 // bottom of the dB scale, relative to the loudest sample
+
+func (sc waveScale) dy(v float64) int {
+	a := math.Abs(v) / sc.max
+	if sc.log {
+		if a > 0 {
+			a = math.Max(0, 1 - 20 * math.Log10(a) / waveFloorDB)
+		}
+	}
+	d := int(math.Min(a, 1) * float64(sc.half))
+	if v < 0 {
+		return -d
 	}
-	if chMax > 0 {
-		max = int(float64(chMax) / yscale)
+	return d
+}
+
+/* draws one pixel column; the first channel in fg[0], the rest in fg[1] with
+ * any overlap with the first in fg[2] */
+func drawColumn(dst draw.Image, rects []image.Rectangle, fg [3]color.RGBA) {
+	for c, rect := range rects {
+		if c == 0 {
+			draw.Draw(dst, rect, &image.Uniform{fg[0]}, image.ZP, draw.Src)
+			continue
+		}
+		draw.Draw(dst, rect, &image.Uniform{fg[1]}, image.ZP, draw.Src)
+		if ri := rect.Intersect(rects[0]); !ri.Empty() {
+			draw.Draw(dst, ri, &image.Uniform{fg[2]}, image.ZP, draw.Src)
+		}
 	}
-	return min, max
 }
 
 func (ww *WaveWidget) drawWave(dst draw.Image, r image.Rectangle, pos *FramePos) {
 	bg := color.RGBA{0xee, 0xee, 0xcc, 255}
-	cl := color.RGBA{0x99, 0x99, 0xcc, 255}
-	ci := color.RGBA{0xbb, 0x99, 0xbb, 255}
-	cr := color.RGBA{0xbb, 0x99, 0x99, 255}
+	peakfg := [3]color.RGBA{{0x99, 0x99, 0xcc, 255}, {0xbb, 0x99, 0x99, 255}, {0xbb, 0x99, 0xbb, 255}}
+	rmsfg := [3]color.RGBA{{0x66, 0x66, 0xaa, 255}, {0x99, 0x66, 0x66, 255}, {0x88, 0x66, 0x88, 255}}
 	draw.Draw(dst, r, &image.Uniform{bg}, image.ZP, draw.Src)
 	if ww.wav == nil {
 		return
@@ -289,25 +315,46 @@ func (ww *WaveWidget) drawWave(dst draw.Image, r image.Rectangle, pos *FramePos)
 	if dx0 >= r.Dx() {
 		return
 	}
-	halfy := r.Dy() / 2
-	yorigin := r.Min.Y + halfy
-	yscale := (float64(ww.wav.MaxAmp()) / float64(halfy))
-	chunks := ww.wav.GetFrames(f0_get, f0 + FrameN(r.Dx()) * fpp)
+	disp := ww.display
+	nlanes := 1
+	if disp.Split {
+		nlanes = ww.wav.Channels
+	}
+	laneh := r.Dy() / nlanes
+	sc := waveScale{laneh / 2, math.Max(1, float64(ww.wav.MaxAmp())), disp.Log}
+	if disp.Split {
+		/* mark the boundary between lanes */
+		for lane := 1; lane < nlanes; lane++ {
+			y := r.Min.Y + lane * laneh
+			draw.Draw(dst, image.Rect(r.Min.X, y, r.Max.X, y + 1), &image.Uniform{peakfg[2]}, image.ZP, draw.Src)
+		}
+	}
+	peaks := make([]image.Rectangle, ww.wav.Channels)
+	rms := make([]image.Rectangle, ww.wav.Channels)
+	var chunks []*wave.Chunk
 	for dx := dx0; dx < r.Dx(); dx++ {
-		pixS0, pixSN := ww.wav.SampleRange(f0 + fpp * FrameN(dx), f0 + fpp * FrameN(dx+1))
-		pixSamples := wave.Extract(chunks, pixS0, pixSN)
-		ext := ww.wav.ChannelExtents(pixSamples)
-		/* FIXME remove two channel assumption */
-		lmin, lmax := scale(ext[0], ext[1], yscale)
-		rmin, rmax := scale(ext[2], ext[3], yscale)
+		pixF0, pixFN := f0 + fpp * FrameN(dx), f0 + fpp * FrameN(dx+1)
+		ext, ok := ww.wav.Extents(pixF0, pixFN)
+		if !ok {
+			if chunks == nil {
+				chunks = ww.wav.GetFrames(f0_get, f0 + FrameN(r.Dx()) * fpp)
+			}
+			pixS0, pixSN := ww.wav.SampleRange(pixF0, pixFN)
+			ext = ww.wav.Summarise(wave.Extract(chunks, pixS0, pixSN))
+		}
 		x := r.Min.X + dx
-		rl := image.Rect(x, yorigin - lmax, x + 1, yorigin - lmin + 1)
-		rr := image.Rect(x, yorigin - rmax, x + 1, yorigin - rmin + 1)
-		ri := rl.Intersect(rr)
-		draw.Draw(dst, rl, &image.Uniform{cl}, image.ZP, draw.Src)
-		draw.Draw(dst, rr, &image.Uniform{cr}, image.ZP, draw.Src)
-		if !ri.Empty() {
-			draw.Draw(dst, ri, &image.Uniform{ci}, image.ZP, draw.Src)
+		for c, s := range ext {
+			yorigin := r.Min.Y + laneh / 2
+			if disp.Split {
+				yorigin += c * laneh
+			}
+			peaks[c] = image.Rect(x, yorigin - sc.dy(float64(s.Max)), x + 1, yorigin - sc.dy(float64(s.Min)) + 1)
+			a := sc.dy(s.RMS())
+			rms[c] = image.Rect(x, yorigin - a, x + 1, yorigin + a + 1)
+		}
+		drawColumn(dst, peaks, peakfg)
+		if disp.RMS {
+			drawColumn(dst, rms, rmsfg)
 		}
 	}
 }
@@ -399,6 +446,9 @@ func (ww *WaveWidget) drawScale(dst draw.Image, r image.Rectangle, infow int, po
 
 		ww.drawNotes(dst, r, staff, mid, selRect, pos)
 
+		ww.drawSuggestion(dst, r, staff, mid, pos)
+		ww.drawMissed(dst, r, staff, mid, pos)
+
 		ww.drawProspectiveNote(dst, r, staff, mid, pos)
 	}
 	if selRect != nil {
@@ -589,6 +639,10 @@ func (ww *WaveWidget) drawNotes(dst draw.Image, r image.Rectangle, staff *score.
 				note.col = color.NRGBA{0x88, 0x88, 0x88, α}
 			} else if note.pt != nil && selRect != nil && note.pt.In(*selRect) {
 				note.col = color.NRGBA{0x66, 0x66, 0xaa, 0xff}
+			} else if ww.verify != nil && ww.verify.Flagged(chord[i].Note) {
+				note.col = color.NRGBA{0xcc, 0x22, 0x22, 0xff}
+			} else if chord[i].Note.Muted {
+				note.col = color.NRGBA{0, 0, 0, 0x44}
 			} else {
 				note.col = color.NRGBA{0, 0, 0, 0xff}
 			}
@@ -598,6 +652,38 @@ func (ww *WaveWidget) drawNotes(dst draw.Image, r image.Rectangle, staff *score.
 	}
 }
 
+func (ww *WaveWidget) drawSuggestion(dst draw.Image, r image.Rectangle, staff *score.Staff, mid int, pos *FramePos) {
+	sugg := ww.suggest
+	if sugg == nil {
+		return
+	}
+	for i, sn := range sugg.Notes {
+		if sn.Staff != staff {
+			continue
+		}
+		dn := ww.dispNote(staff, sn.Note, mid, pos)
+		α := uint8(0x44 + 0xaa * sugg.Confidence[i])
+		dn.col = color.NRGBA{0x00, 0x88, 0x22, α}
+		ww.drawNote(dst, r, mid, dn)
+	}
+}
+
+/* draws notes the verification thinks are missing from the transcription */
+func (ww *WaveWidget) drawMissed(dst draw.Image, r image.Rectangle, staff *score.Staff, mid int, pos *FramePos) {
+	v := ww.verify
+	if v == nil {
+		return
+	}
+	for _, sn := range v.Missed {
+		if sn.Staff != staff {
+			continue
+		}
+		dn := ww.dispNote(staff, sn.Note, mid, pos)
+		dn.col = color.NRGBA{0xee, 0x88, 0x00, 0x88}
+		ww.drawNote(dst, r, mid, dn)
+	}
+}
+
 func (ww *WaveWidget) drawProspectiveNote(dst draw.Image, r image.Rectangle, staff *score.Staff, mid int, pos *FramePos) {
 	s := ww.getMouseState(ww.mouse.pos)
 	if s.rectSelect != nil {
@@ -121,6 +121,9 @@ func (ww *WaveWidget) ButtonDown(e wde.MouseDownEvent) DragFn {
 		case wde.RightButton:
 			return ww.placeNoteDrag(e.Where)
 		case wde.LeftButton:
+			if G.kb.ctrl {
+				return ww.scrubDrag(e.Where)
+			}
 			return ww.getMouseState(e.Where).dragFn
 		}
 	} else {